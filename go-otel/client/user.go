@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"encoding/json"
 	"bytes"
 	"math/rand"
@@ -33,7 +34,7 @@ type User struct {
 	Email     string `json:"email"`
 	Age       int    `json:"age"`
 	ID        int64  `json:"id,omitempty"`
-	CreatedAt int64  `json:"created_at,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
 }
 
 func (c *Client) runUserRequests(ctx context.Context) {
@@ -122,7 +123,7 @@ func (c *Client) getUserByPhone(ctx context.Context, phone string) error {
 
 	span.SetAttributes(attribute.String("user.query.phone", phone))
 
-	url := fmt.Sprintf("%s/user?phone=%s", c.serverURL, phone)
+	url := fmt.Sprintf("%s/user?phone=%s", c.serverURL, neturl.QueryEscape(phone))
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		span.SetStatus(codes.Error, "failed to create request")
@@ -269,7 +270,7 @@ func (c *Client) getUserByName(ctx context.Context, name string) error {
 
 	span.SetAttributes(attribute.String("user.query.name", name))
 
-	url := fmt.Sprintf("%s/user?name=%s", c.serverURL, name)
+	url := fmt.Sprintf("%s/user?name=%s", c.serverURL, neturl.QueryEscape(name))
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		span.SetStatus(codes.Error, "failed to create request")
@@ -323,7 +324,8 @@ func (c *Client) generateRandomUser() User {
 		gender = "female"
 	}
 
-	phone := fmt.Sprintf("1%d", c.randomBetween(3000000000, 9999999999))
+	// 服务端要求 E.164 格式
+	phone := fmt.Sprintf("+861%d", c.randomBetween(3000000000, 9999999999))
 	email := fmt.Sprintf("%s@example.com", strings.ToLower(name))
 	age := c.randomBetween(18, 80)
 
@@ -22,7 +22,9 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	mcpmeta "github.com/flashcatcloud/Demo/go-otel/pkg/mcp/meta"
 	pkgotel "github.com/flashcatcloud/Demo/go-otel/pkg/otel"
 )
 
@@ -32,8 +34,17 @@ const (
 
 var (
 	// 从环境变量获取端口配置，如果未设置则使用默认值
-	mcpPort     = getEnvWithDefault("MCP_SSE_PORT", ":8184") // MCP SSE服务端口
+	mcpPort     = getEnvWithDefault("MCP_SSE_PORT", ":8184") // MCP SSE/Streamable HTTP服务端口
 	metricsPort = getEnvWithDefault("METRICS_PORT", ":8185") // Prometheus指标端口
+	// MCP传输方式: sse、streamable-http 或 stdio
+	mcpTransport = getEnvWithDefault("MCP_TRANSPORT", "sse")
+	// 客户端访问本服务的地址，SSE传输据此生成消息端点
+	mcpBaseURL = getEnvWithDefault("MCP_BASE_URL", "http://localhost"+mcpPort)
+	// Streamable HTTP会话多久没有请求后视为已断开
+	sessionIdleTimeout = getEnvWithDefault("MCP_SESSION_IDLE_TIMEOUT", "10m")
+
+	// 跟踪活跃的MCP会话，在main中按传输方式创建
+	sessions *sessionTracker
 
 	tracer = otel.Tracer(serviceName)
 
@@ -71,11 +82,20 @@ func init() {
 
 func main() {
 	// 打印端口配置信息
+	if mcpTransport == "http" {
+		mcpTransport = "streamable-http"
+	}
+	if mcpTransport == "stdio" {
+		// stdout 只能输出 MCP 协议消息，gin 的日志改写到 stderr
+		gin.DefaultWriter = os.Stderr
+	}
+
 	log.Printf("服务配置:")
-	log.Printf("  - MCP SSE服务端口: %s (环境变量: MCP_SSE_PORT)", mcpPort)
-	log.Printf("  - Metrics监控端口: %s (环境变量: METRICS_PORT)", metricsPort)
+	log.Printf("  - MCP传输方式: %s (环境变量: MCP_TRANSPORT)", mcpTransport)
+	log.Printf("  - MCP服务端口: %s (环境变量: MCP_SSE_PORT，stdio传输不使用)", mcpPort)
+	log.Printf("  - Metrics监控端口: %s (环境变量: METRICS_PORT，stdio传输需显式设置)", metricsPort)
 	log.Printf("端口说明:")
-	log.Printf("  - MCP SSE端口: 用于MCP协议通信和工具调用")
+	log.Printf("  - MCP端口: 用于MCP协议通信和工具调用")
 	log.Printf("  - Metrics端口: 用于Prometheus指标、健康检查和状态监控")
 
 	// 平滑处理 SIGINT (CTRL+C)
@@ -92,67 +112,129 @@ func main() {
 		err = errors.Join(err, otelShutdown(ctx))
 	}()
 
+	idleTimeout, err := time.ParseDuration(sessionIdleTimeout)
+	if err != nil || idleTimeout <= 0 {
+		log.Fatalf("Invalid MCP_SESSION_IDLE_TIMEOUT %q", sessionIdleTimeout)
+	}
+	sessions = newSessionTracker(mcpTransport, idleTimeout)
+
 	// Create MCP server
-	s := server.NewMCPServer(serviceName, "0.0.1")
+	s := server.NewMCPServer(serviceName, "0.0.1",
+		server.WithToolHandlerMiddleware(traceToolCall),
+		server.WithHooks(sessions.hooks()),
+	)
 
 	// Add tools
 	setupTools(s)
 
-	// Start metrics server in a goroutine
-	go startMetricsServer()
-
-	// Create SSE server using mark3labs/mcp-go
-	log.Printf("Starting MCP SSE server on %s", mcpPort)
-	sseServer := server.NewSSEServer(s,
-		server.WithBaseURL("http://localhost"+mcpPort),
-		server.WithSSEEndpoint("/sse"),
-		server.WithMessageEndpoint("/message"),
-		server.WithSSEContextFunc(func(ctx context.Context, r *http.Request) context.Context {
-			// Add tracing context
-			_, span := tracer.Start(ctx, "mcp.sse.connection")
-			defer span.End()
-
-			// Update metrics
-			connectedClients.Inc()
-
-			span.SetAttributes(
-				attribute.String("transport", "sse"),
-				attribute.String("endpoint", "/sse"),
-			)
-			return ctx
-		}),
-	)
+	// stdio 传输下本服务是客户端的子进程，每个客户端各启动一个，固定的指标端口会互相冲突，
+	// 所以只在显式设置了 METRICS_PORT 时才启动
+	if mcpTransport != "stdio" || os.Getenv("METRICS_PORT") != "" {
+		go startMetricsServer()
+	} else {
+		log.Printf("stdio 传输未设置 METRICS_PORT，不启动 metrics 服务")
+	}
 
-	// Start SSE server
-	sseErr := make(chan error, 1)
+	// Create MCP transport server using mark3labs/mcp-go
+	mcpServer, err := newTransportServer(ctx, s)
+	if err != nil {
+		log.Fatalf("Failed to create MCP server: %v", err)
+	}
+	log.Printf("Starting MCP %s server on %s", mcpTransport, mcpEndpoint())
+
+	// Start MCP server
+	mcpErr := make(chan error, 1)
 	go func() {
-		sseErr <- sseServer.Start(mcpPort)
+		mcpErr <- mcpServer.Start(mcpPort)
 	}()
 
 	// Wait for interruption
 	select {
-	case err = <-sseErr:
-		// Error when starting SSE server
+	case err = <-mcpErr:
+		// Error when starting MCP server, stdio server returns nil when stdin is closed
 		if err != nil && err != http.ErrServerClosed {
-			log.Printf("SSE server error: %v", err)
+			log.Printf("MCP %s server error: %v", mcpTransport, err)
 		}
 	case <-ctx.Done():
 		// Wait for first CTRL+C
 		stop()
-		log.Println("Shutting down SSE server...")
+		log.Printf("Shutting down MCP %s server...", mcpTransport)
 
-		// Gracefully shutdown SSE server
+		// Gracefully shutdown MCP server
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		if err := sseServer.Shutdown(shutdownCtx); err != nil {
-			log.Printf("SSE server forced to shutdown: %v", err)
+		if err := mcpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("MCP %s server forced to shutdown: %v", mcpTransport, err)
 		}
 	}
+	// 结束剩余会话的 span，确保在 OpenTelemetry 关闭前导出
+	sessions.closeAll(closeShutdown)
 
 	log.Println("MCP server exiting")
 }
 
+// transportServer 是 SSE、Streamable HTTP 和 stdio 服务的共同接口
+type transportServer interface {
+	Start(addr string) error
+	Shutdown(ctx context.Context) error
+}
+
+// stdioServer 在标准输入输出上提供 MCP 服务，Start 的地址参数不使用
+type stdioServer struct {
+	*server.StdioServer
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *stdioServer) Start(string) error {
+	return s.Listen(s.ctx, os.Stdin, os.Stdout)
+}
+
+func (s *stdioServer) Shutdown(context.Context) error {
+	s.cancel()
+	return nil
+}
+
+// newTransportServer 按 MCP_TRANSPORT 创建服务，三种传输共用同一个 MCPServer 和 traceToolCall 中间件
+func newTransportServer(ctx context.Context, s *server.MCPServer) (transportServer, error) {
+	switch mcpTransport {
+	case "sse":
+		return server.NewSSEServer(s,
+			server.WithBaseURL(mcpBaseURL),
+			server.WithSSEEndpoint("/sse"),
+			server.WithMessageEndpoint("/message"),
+		), nil
+	case "streamable-http":
+		// POST请求不会注册会话，由sessionTracker在initialize时开始跟踪，在DELETE请求或空闲超时后结束
+		httpServer := &http.Server{}
+		streamable := server.NewStreamableHTTPServer(s,
+			server.WithEndpointPath("/mcp"),
+			server.WithStreamableHTTPServer(httpServer),
+		)
+		mux := http.NewServeMux()
+		mux.Handle("/mcp", sessions.httpHandler(streamable))
+		httpServer.Handler = mux
+		sessions.startIdleSweeper(ctx)
+		return streamable, nil
+	case "stdio":
+		ctx, cancel := context.WithCancel(ctx)
+		return &stdioServer{StdioServer: server.NewStdioServer(s), ctx: ctx, cancel: cancel}, nil
+	}
+	return nil, fmt.Errorf("unknown MCP_TRANSPORT %q, expected sse, streamable-http or stdio", mcpTransport)
+}
+
+// mcpEndpoint 返回客户端连接本服务使用的地址
+func mcpEndpoint() string {
+	switch mcpTransport {
+	case "sse":
+		return mcpBaseURL + "/sse"
+	case "streamable-http":
+		return mcpBaseURL + "/mcp"
+	}
+	return mcpTransport
+}
+
 func setupTools(s *server.MCPServer) {
 	// Echo tool
 	echoTool := mcp.NewTool("echo",
@@ -198,6 +280,38 @@ func setupTools(s *server.MCPServer) {
 	log.Println("MCP tools registered successfully")
 }
 
+// traceToolCall 从请求的 _meta 中恢复调用方的 trace context，并为每次工具调用创建 server span，
+// 各工具处理函数的 span 都是它的子 span。
+func traceToolCall(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx = mcpmeta.Extract(ctx, request.Params.Meta)
+		ctx, span := tracer.Start(ctx, "tools/call "+request.Params.Name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "jsonrpc"),
+				attribute.String("rpc.method", "tools/call"),
+				attribute.String("mcp.tool", request.Params.Name),
+			))
+		defer span.End()
+
+		// 在会话 span 下记录这次调用，请求 span 仍然属于调用方的 trace
+		sessionSpan := sessions.startToolCall(ctx, request.Params.Name, span.SpanContext())
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			span.SetAttributes(attribute.String("mcp.session.id", session.SessionID()))
+		}
+
+		result, err := next(ctx, request)
+		recordToolCall(sessionSpan, result, err)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else if result != nil && result.IsError {
+			span.SetStatus(codes.Error, "tool returned an error")
+		}
+		return result, err
+	}
+}
+
 func handleEcho(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	_, span := tracer.Start(ctx, "mcp.tool.echo")
 	defer span.End()
@@ -384,8 +498,22 @@ func startMetricsServer() {
 			"server":            serviceName,
 			"version":           "0.0.1",
 			"connected_clients": connectedClientsValue,
-			"transport":         "sse",
-			"endpoint":          "http://localhost" + mcpPort + "/sse",
+			"transport":         mcpTransport,
+			"endpoint":          mcpEndpoint(),
+		})
+	})
+
+	// Active sessions endpoint
+	r.GET("/sessions", func(c *gin.Context) {
+		_, span := tracer.Start(c.Request.Context(), "metrics.sessions")
+		defer span.End()
+
+		list := sessions.list()
+		span.SetAttributes(attribute.Int("mcp.sessions.active", len(list)))
+		c.JSON(http.StatusOK, gin.H{
+			"transport": mcpTransport,
+			"count":     len(list),
+			"sessions":  list,
 		})
 	})
 
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 会话结束的原因
+const (
+	closeDisconnected = "disconnected"
+	closeTerminated   = "terminated"
+	closeIdleTimeout  = "idle_timeout"
+	closeShutdown     = "shutdown"
+)
+
+// headerSessionID 是 Streamable HTTP 传输携带会话 ID 的请求头
+const headerSessionID = "Mcp-Session-Id"
+
+var sessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "mcp_session_duration_seconds",
+	Help:    "Duration of MCP client sessions",
+	Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+}, []string{"transport", "reason"})
+
+func init() {
+	prometheus.MustRegister(sessionDuration)
+}
+
+// sessionInfo 是 /sessions 接口返回的一个活跃会话。Streamable HTTP 下会话 ID 就是访问凭证，
+// 接口只返回它的短哈希 Ref，足够与日志和 trace 对照，但不能用来冒用或结束会话
+type sessionInfo struct {
+	Ref             string    `json:"ref"`
+	Transport       string    `json:"transport"`
+	ClientName      string    `json:"client_name,omitempty"`
+	ClientVersion   string    `json:"client_version,omitempty"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	LastActiveAt    time.Time `json:"last_active_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ToolCalls       int64     `json:"tool_calls"`
+}
+
+// trackedSession 是一个活跃会话，span 从会话开始持续到会话结束，工具调用的 span 是它的子 span
+type trackedSession struct {
+	id   string
+	info sessionInfo
+	ctx  context.Context
+	span trace.Span
+	// lazy 表示会话不是由 MCPServer 注册的（Streamable HTTP 的 POST 请求不注册会话），
+	// 只能通过 DELETE 请求或空闲超时结束
+	lazy bool
+}
+
+// sessionTracker 跟踪 MCP 会话的生命周期，维护 connectedClients 和会话时长指标
+type sessionTracker struct {
+	transport   string
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*trackedSession
+}
+
+func newSessionTracker(transport string, idleTimeout time.Duration) *sessionTracker {
+	return &sessionTracker{
+		transport:   transport,
+		idleTimeout: idleTimeout,
+		sessions:    map[string]*trackedSession{},
+	}
+}
+
+// hooks 返回注册到 MCPServer 的会话钩子
+func (t *sessionTracker) hooks() *server.Hooks {
+	hooks := &server.Hooks{}
+	hooks.AddOnRegisterSession(func(ctx context.Context, session server.ClientSession) {
+		t.open(ctx, session.SessionID(), false)
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		t.close(session.SessionID(), closeDisconnected, true)
+	})
+	hooks.AddAfterInitialize(func(ctx context.Context, id any, request *mcp.InitializeRequest, result *mcp.InitializeResult) {
+		session := server.ClientSessionFromContext(ctx)
+		if session == nil {
+			return
+		}
+		t.open(ctx, session.SessionID(), true)
+		t.initialized(session.SessionID(), request)
+	})
+	hooks.AddBeforeAny(func(ctx context.Context, id any, method mcp.MCPMethod, message any) {
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			t.touch(session.SessionID())
+		}
+	})
+	return hooks
+}
+
+// open 开始跟踪会话 id，已经在跟踪时忽略。会话 span 是新的根 span，不属于建立连接的请求
+func (t *sessionTracker) open(ctx context.Context, id string, lazy bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.sessions[id]; ok {
+		return
+	}
+	ref := sessionRef(id)
+	sctx, span := tracer.Start(ctx, "mcp.session",
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("mcp.session.id", id),
+			attribute.String("mcp.session.ref", ref),
+			attribute.String("mcp.transport", t.transport),
+		))
+	now := time.Now()
+	t.sessions[id] = &trackedSession{
+		id:   id,
+		info: sessionInfo{Ref: ref, Transport: t.transport, StartedAt: now, LastActiveAt: now},
+		ctx:  sctx,
+		span: span,
+		lazy: lazy,
+	}
+	connectedClients.Inc()
+	log.Printf("MCP session %s opened (%s)", ref, t.transport)
+}
+
+// initialized 记录 initialize 请求中的客户端信息
+func (t *sessionTracker) initialized(id string, request *mcp.InitializeRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[id]
+	if !ok {
+		return
+	}
+	s.info.ClientName = request.Params.ClientInfo.Name
+	s.info.ClientVersion = request.Params.ClientInfo.Version
+	s.info.ProtocolVersion = request.Params.ProtocolVersion
+	s.span.SetAttributes(
+		attribute.String("mcp.client.name", s.info.ClientName),
+		attribute.String("mcp.client.version", s.info.ClientVersion),
+		attribute.String("mcp.protocol.version", s.info.ProtocolVersion),
+	)
+	s.span.AddEvent("initialize")
+}
+
+func (t *sessionTracker) touch(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.sessions[id]; ok {
+		s.info.LastActiveAt = time.Now()
+	}
+}
+
+// close 结束会话 id 的 span 并记录时长。fromRegistry 为 true 时只结束由 MCPServer 注册的会话
+func (t *sessionTracker) close(id, reason string, fromRegistry bool) {
+	t.mu.Lock()
+	s, ok := t.sessions[id]
+	if !ok || (fromRegistry && s.lazy) {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.sessions, id)
+	t.mu.Unlock()
+
+	duration := time.Since(s.info.StartedAt)
+	sessionDuration.WithLabelValues(t.transport, reason).Observe(duration.Seconds())
+	connectedClients.Dec()
+	s.span.SetAttributes(
+		attribute.String("mcp.session.close_reason", reason),
+		attribute.Int64("mcp.session.tool_calls", s.info.ToolCalls),
+	)
+	s.span.End()
+	log.Printf("MCP session %s closed (%s) after %s, %d tool calls", s.info.Ref, reason, duration.Round(time.Millisecond), s.info.ToolCalls)
+}
+
+// closeAll 在服务退出时结束所有会话，保证会话 span 在 OpenTelemetry 关闭前导出
+func (t *sessionTracker) closeAll(reason string) {
+	t.mu.Lock()
+	ids := make([]string, 0, len(t.sessions))
+	for id := range t.sessions {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+	for _, id := range ids {
+		t.close(id, reason, false)
+	}
+}
+
+// startToolCall 在 ctx 所属会话的 span 下开始一个工具调用子 span，并链接到 link（处理请求的 span）。
+// 工具调用的请求 span 通过 _meta 挂在调用方的 trace 下，这里的子 span 用于在会话 trace 中查看每次调用。
+// 会话未被跟踪时返回不记录的 span
+func (t *sessionTracker) startToolCall(ctx context.Context, tool string, link trace.SpanContext) trace.Span {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return trace.SpanFromContext(context.Background())
+	}
+	t.mu.Lock()
+	s, ok := t.sessions[session.SessionID()]
+	if ok {
+		s.info.ToolCalls++
+		s.info.LastActiveAt = time.Now()
+	}
+	t.mu.Unlock()
+	if !ok {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := tracer.Start(s.ctx, "mcp.session.tool_call",
+		trace.WithLinks(trace.Link{SpanContext: link}),
+		trace.WithAttributes(
+			attribute.String("mcp.session.id", s.id),
+			attribute.String("mcp.tool", tool),
+		))
+	return span
+}
+
+// sessionRef 返回会话 ID 的短哈希
+func sessionRef(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:6])
+}
+
+// list 返回活跃会话，按开始时间排序
+func (t *sessionTracker) list() []sessionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	list := make([]sessionInfo, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		info := s.info
+		info.DurationSeconds = now.Sub(info.StartedAt).Seconds()
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list
+}
+
+// startIdleSweeper 定期结束超过 idleTimeout 没有请求的会话，只用于 Streamable HTTP：
+// 客户端异常退出时不会发送 DELETE 请求
+func (t *sessionTracker) startIdleSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(min(t.idleTimeout/2, time.Minute))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			t.mu.Lock()
+			var idle []string
+			for id, s := range t.sessions {
+				if time.Since(s.info.LastActiveAt) > t.idleTimeout {
+					idle = append(idle, id)
+				}
+			}
+			t.mu.Unlock()
+			for _, id := range idle {
+				t.close(id, closeIdleTimeout, false)
+			}
+		}
+	}()
+}
+
+// httpHandler 包装 Streamable HTTP 服务，客户端用 DELETE 请求结束会话且服务端返回 2xx 时结束跟踪
+func (t *sessionTracker) httpHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerSessionID)
+		if r.Method != http.MethodDelete || id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// 会话不存在或请求被拒绝时不能关闭会话，只有服务端确认终止后才算结束
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status >= 200 && sw.status < 300 {
+			t.close(id, closeTerminated, false)
+		}
+	})
+}
+
+// statusWriter 记录写出的 HTTP 状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// recordToolCall 结束工具调用子 span 并记录结果
+func recordToolCall(span trace.Span, result *mcp.CallToolResult, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if result != nil && result.IsError {
+		span.SetStatus(codes.Error, "tool returned an error")
+	}
+	span.End()
+}
@@ -0,0 +1,253 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "dice"
+
+// 求值阶段的限制
+const (
+	// MaxTotalDice 是一次求值中掷骰的总次数上限，包括爆炸追加的骰子
+	MaxTotalDice = 500
+	// MaxExplosions 是单个骰子最多连续爆炸的次数
+	MaxExplosions = 10
+)
+
+// ErrTooManyDice 表示求值过程中掷骰次数超过 MaxTotalDice。
+var ErrTooManyDice = errors.New("dice: too many dice rolled")
+
+// ErrOverflow 表示运算结果超出 int 的范围。
+var ErrOverflow = errors.New("dice: result overflows int")
+
+// Rand 是掷骰使用的随机数来源，*rand.Rand 满足该接口。
+type Rand interface {
+	// Intn 返回 [0, n) 之间的随机整数
+	Intn(n int) int
+}
+
+// globalRand 使用 math/rand 的全局随机源。
+type globalRand struct{}
+
+func (globalRand) Intn(n int) int { return rand.Intn(n) }
+
+// Combiner 计算二元运算 x op y，op 为 '+'、'-' 或 '*'。
+// 可以替换为远程计算（如 MCP calculator 工具）。
+type Combiner func(ctx context.Context, op byte, x, y int) (int, error)
+
+// LocalCombine 在本地完成二元运算，结果溢出时返回 ErrOverflow。
+func LocalCombine(_ context.Context, op byte, x, y int) (int, error) {
+	switch op {
+	case '+':
+		r := x + y
+		if (x > 0 && y > 0 && r < 0) || (x < 0 && y < 0 && r >= 0) {
+			return 0, ErrOverflow
+		}
+		return r, nil
+	case '-':
+		r := x - y
+		if (x >= 0 && y < 0 && r < 0) || (x < 0 && y > 0 && r >= 0) {
+			return 0, ErrOverflow
+		}
+		return r, nil
+	case '*':
+		r := x * y
+		if x != 0 && (r/x != y || (x == -1 && y == math.MinInt)) {
+			return 0, ErrOverflow
+		}
+		return r, nil
+	}
+	return 0, fmt.Errorf("dice: unknown operator %q", op)
+}
+
+// Die 是一个骰子的结果。
+type Die struct {
+	Value int `json:"value"`
+	// Kept 为 false 表示被 kh/kl/dh/dl 规则丢弃
+	Kept bool `json:"kept"`
+	// Explosions 是该骰子因掷出最大值而追加的次数，Value 已包含追加的点数
+	Explosions int `json:"explosions,omitempty"`
+}
+
+// RollResult 是表达式中一组骰子的结果。
+type RollResult struct {
+	Notation string `json:"notation"`
+	Dice     []Die  `json:"dice"`
+	Subtotal int    `json:"subtotal"`
+}
+
+// Result 是整个表达式的求值结果。
+type Result struct {
+	Expression string       `json:"expression"`
+	Total      int          `json:"total"`
+	Rolls      []RollResult `json:"rolls"`
+}
+
+// Evaluator 对骰子表达式求值。零值可用：使用全局随机源和本地运算。
+type Evaluator struct {
+	Rand    Rand
+	Combine Combiner
+}
+
+// Eval 解析并求值 expr，解析、每组掷骰以及最终汇总都会创建子 span。
+func (e *Evaluator) Eval(ctx context.Context, expr string) (*Result, error) {
+	tracer := otel.Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, "dice.evaluate", gotrace.WithAttributes(
+		attribute.String("dice.expression", expr),
+	))
+	defer span.End()
+
+	_, parseSpan := tracer.Start(ctx, "dice.parse")
+	ast, err := Parse(expr)
+	if err != nil {
+		parseSpan.RecordError(err)
+		parseSpan.SetStatus(codes.Error, "parse failed")
+		parseSpan.End()
+		span.SetStatus(codes.Error, "parse failed")
+		return nil, err
+	}
+	parseSpan.SetAttributes(attribute.String("dice.ast", ast.String()))
+	parseSpan.End()
+
+	st := &evalState{Evaluator: e, tracer: tracer}
+	if st.Rand == nil {
+		st.Rand = globalRand{}
+	}
+	if st.Combine == nil {
+		st.Combine = LocalCombine
+	}
+	total, err := st.eval(ctx, ast)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("dice.total", total),
+		attribute.Int("dice.rolled", st.rolled),
+	)
+	return &Result{Expression: expr, Total: total, Rolls: st.rolls}, nil
+}
+
+type evalState struct {
+	*Evaluator
+	tracer gotrace.Tracer
+	rolls  []RollResult
+	rolled int
+}
+
+func (st *evalState) eval(ctx context.Context, n Node) (int, error) {
+	switch n := n.(type) {
+	case *Number:
+		return n.Value, nil
+	case *Roll:
+		return st.roll(ctx, n)
+	case *Binary:
+		x, err := st.eval(ctx, n.Left)
+		if err != nil {
+			return 0, err
+		}
+		y, err := st.eval(ctx, n.Right)
+		if err != nil {
+			return 0, err
+		}
+		// 远程 Combiner 以浮点数计算，无法发现溢出，先在本地检查
+		if _, err := LocalCombine(ctx, n.Op, x, y); errors.Is(err, ErrOverflow) {
+			return 0, err
+		}
+		return st.Combine(ctx, n.Op, x, y)
+	}
+	return 0, fmt.Errorf("dice: unknown node %T", n)
+}
+
+func (st *evalState) rollDie(sides int) (int, error) {
+	st.rolled++
+	if st.rolled > MaxTotalDice {
+		return 0, ErrTooManyDice
+	}
+	return 1 + st.Rand.Intn(sides), nil
+}
+
+func (st *evalState) roll(ctx context.Context, r *Roll) (int, error) {
+	_, span := st.tracer.Start(ctx, "dice.roll "+r.String(), gotrace.WithAttributes(
+		attribute.Int("dice.count", r.Count),
+		attribute.Int("dice.sides", r.Sides),
+		attribute.Bool("dice.explode", r.Explode),
+	))
+	defer span.End()
+
+	dice := make([]Die, r.Count)
+	for i := range dice {
+		v, err := st.rollDie(r.Sides)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return 0, err
+		}
+		d := Die{Value: v, Kept: true}
+		for last := v; r.Explode && last == r.Sides && d.Explosions < MaxExplosions; {
+			if last, err = st.rollDie(r.Sides); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return 0, err
+			}
+			d.Value += last
+			d.Explosions++
+		}
+		dice[i] = d
+	}
+	if r.Keep != nil {
+		applyKeep(dice, r.Keep)
+	}
+
+	subtotal := 0
+	values := make([]int, 0, len(dice))
+	for _, d := range dice {
+		if d.Kept {
+			subtotal += d.Value
+		}
+		values = append(values, d.Value)
+	}
+	st.rolls = append(st.rolls, RollResult{Notation: r.String(), Dice: dice, Subtotal: subtotal})
+	span.SetAttributes(
+		attribute.IntSlice("dice.values", values),
+		attribute.Int("dice.subtotal", subtotal),
+	)
+	return subtotal, nil
+}
+
+// applyKeep 按规则把不保留的骰子标记为 Kept=false。
+func applyKeep(dice []Die, k *KeepRule) {
+	idx := make([]int, len(dice))
+	for i := range idx {
+		idx[i] = i
+	}
+	// 按点数升序，点数相同时保持原顺序
+	sort.SliceStable(idx, func(a, b int) bool { return dice[idx[a]].Value < dice[idx[b]].Value })
+
+	var drop []int
+	switch k.Mode {
+	case "kh":
+		drop = idx[:len(idx)-k.N]
+	case "kl":
+		drop = idx[k.N:]
+	case "dh":
+		drop = idx[len(idx)-k.N:]
+	case "dl":
+		drop = idx[:k.N]
+	}
+	for _, i := range drop {
+		dice[i].Kept = false
+	}
+}
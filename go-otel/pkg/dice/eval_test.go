@@ -0,0 +1,160 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// seqRand 依次掷出 values 中的点数，用完后从头开始
+type seqRand struct {
+	values []int
+	i      int
+}
+
+func (r *seqRand) Intn(n int) int {
+	v := r.values[r.i%len(r.values)]
+	r.i++
+	return (v - 1) % n
+}
+
+func TestLocalCombine(t *testing.T) {
+	cases := []struct {
+		op   byte
+		x, y int
+		want int
+		err  error
+	}{
+		{'+', 2, 3, 5, nil},
+		{'+', math.MaxInt, math.MinInt, -1, nil},
+		{'+', math.MaxInt, 1, 0, ErrOverflow},
+		{'+', math.MinInt, -1, 0, ErrOverflow},
+		{'-', 2, 5, -3, nil},
+		{'-', -1, math.MinInt, math.MaxInt, nil},
+		{'-', math.MinInt, 1, 0, ErrOverflow},
+		{'-', 0, math.MinInt, 0, ErrOverflow},
+		{'-', math.MaxInt, -1, 0, ErrOverflow},
+		{'*', 6, -7, -42, nil},
+		{'*', 0, math.MinInt, 0, nil},
+		{'*', -1, math.MaxInt, -math.MaxInt, nil},
+		{'*', math.MaxInt, 2, 0, ErrOverflow},
+		{'*', math.MinInt, -1, 0, ErrOverflow},
+		{'*', -1, math.MinInt, 0, ErrOverflow},
+		{'*', 1 << 32, 1 << 31, 0, ErrOverflow},
+	}
+	for _, tc := range cases {
+		got, err := LocalCombine(context.Background(), tc.op, tc.x, tc.y)
+		if !errors.Is(err, tc.err) || (tc.err == nil && got != tc.want) {
+			t.Errorf("LocalCombine(%c, %d, %d) = %d, %v; want %d, %v", tc.op, tc.x, tc.y, got, err, tc.want, tc.err)
+		}
+	}
+
+	if _, err := LocalCombine(context.Background(), '/', 1, 1); err == nil || errors.Is(err, ErrOverflow) {
+		t.Errorf("LocalCombine('/') error = %v, want unknown operator", err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	cases := []struct {
+		expr  string
+		rolls []int
+		total int
+		// dice 是每组骰子的点数，被丢弃的用负数表示
+		dice [][]int
+	}{
+		{"1d6", []int{4}, 4, [][]int{{4}}},
+		{"2d6+3", []int{2, 5}, 10, [][]int{{2, 5}}},
+		{"4d6kh3", []int{3, 1, 6, 3}, 12, [][]int{{3, -1, 6, 3}}},
+		{"4d6kl1", []int{3, 1, 6, 3}, 1, [][]int{{-3, 1, -6, -3}}},
+		{"4d6dh1", []int{3, 1, 6, 3}, 7, [][]int{{3, 1, -6, 3}}},
+		// 点数相同时先丢弃靠前的骰子
+		{"3d6dl1", []int{3, 5, 3}, 8, [][]int{{-3, 5, 3}}},
+		{"2d6!", []int{6, 6, 2, 3}, 17, [][]int{{14, 3}}},
+		{"1d4*2-1d4", []int{3, 4}, 2, [][]int{{3}, {4}}},
+		{"(1d4+1)*3", []int{2}, 9, [][]int{{2}}},
+	}
+	for _, tc := range cases {
+		e := &Evaluator{Rand: &seqRand{values: tc.rolls}}
+		res, err := e.Eval(context.Background(), tc.expr)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tc.expr, err)
+			continue
+		}
+		if res.Total != tc.total {
+			t.Errorf("Eval(%q) total = %d, want %d", tc.expr, res.Total, tc.total)
+		}
+		if len(res.Rolls) != len(tc.dice) {
+			t.Errorf("Eval(%q) rolls = %+v, want %v", tc.expr, res.Rolls, tc.dice)
+			continue
+		}
+		for i, rr := range res.Rolls {
+			got := make([]int, len(rr.Dice))
+			for j, d := range rr.Dice {
+				got[j] = d.Value
+				if !d.Kept {
+					got[j] = -d.Value
+				}
+			}
+			if !slices.Equal(got, tc.dice[i]) {
+				t.Errorf("Eval(%q) roll %s dice = %v, want %v", tc.expr, rr.Notation, got, tc.dice[i])
+			}
+		}
+	}
+}
+
+func TestEvalExplosionLimit(t *testing.T) {
+	e := &Evaluator{Rand: &seqRand{values: []int{6}}}
+	res, err := e.Eval(context.Background(), "1d6!")
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	d := res.Rolls[0].Dice[0]
+	if d.Explosions != MaxExplosions || d.Value != 6*(MaxExplosions+1) {
+		t.Errorf("die = %+v, want %d explosions", d, MaxExplosions)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	cases := []struct {
+		expr  string
+		rolls []int
+		err   error
+	}{
+		// 每组都在 MaxDicePerTerm 以内，但总数超过 MaxTotalDice
+		{strings.TrimSuffix(strings.Repeat("100d6+", MaxTotalDice/MaxDicePerTerm+1), "+"), []int{1}, ErrTooManyDice},
+		// 爆炸追加的骰子也计入总数
+		{"100d6!", []int{6}, ErrTooManyDice},
+		{"1000000*1000000*1000000*1000000", []int{1}, ErrOverflow},
+		{"0-1000000*1000000*1000000*1000000", []int{1}, ErrOverflow},
+	}
+	for _, tc := range cases {
+		e := &Evaluator{Rand: &seqRand{values: tc.rolls}}
+		if _, err := e.Eval(context.Background(), tc.expr); !errors.Is(err, tc.err) {
+			t.Errorf("Eval(%q) error = %v, want %v", tc.expr, err, tc.err)
+		}
+	}
+
+	var se *SyntaxError
+	if _, err := (&Evaluator{}).Eval(context.Background(), "2d"); !errors.As(err, &se) {
+		t.Errorf("Eval(%q) error = %v, want *SyntaxError", "2d", err)
+	}
+}
+
+// 自定义 Combiner 无法发现溢出时，Eval 仍在本地检查
+func TestEvalOverflowWithRemoteCombiner(t *testing.T) {
+	called := 0
+	e := &Evaluator{Combine: func(ctx context.Context, op byte, x, y int) (int, error) {
+		called++
+		return LocalCombine(ctx, op, x, y)
+	}}
+	if _, err := e.Eval(context.Background(), "1000000*1000000*1000000*1000000"); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("error = %v, want ErrOverflow", err)
+	}
+	// 前两次乘法没有溢出，交给 Combiner；第三次在本地被拒绝
+	if called != 2 {
+		t.Errorf("Combiner called %d times, want 2", called)
+	}
+}
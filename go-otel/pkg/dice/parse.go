@@ -0,0 +1,270 @@
+// Package dice 实现骰子表达式（如 "4d6kh3+2"、"2d20!"）的解析与求值。
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 输入限制，防止恶意表达式占用过多资源
+const (
+	MaxExpressionLength = 64
+	MaxDicePerTerm      = 100
+	MaxSides            = 1000
+	MaxTerms            = 20
+	MaxDepth            = 8
+)
+
+// Node 是表达式 AST 的节点。
+type Node interface {
+	String() string
+}
+
+// Number 是常量，如 "+2" 中的 2。
+type Number struct {
+	Value int
+}
+
+// Roll 是一组骰子，如 "4d6kh3"。
+type Roll struct {
+	Count int
+	Sides int
+	// Keep 为空表示保留全部骰子
+	Keep *KeepRule
+	// Explode 为 true 时，掷出最大值的骰子会再掷一次并累加（"!"）
+	Explode bool
+}
+
+// KeepRule 描述保留或丢弃最高/最低的若干个骰子。
+type KeepRule struct {
+	// Mode 为 kh（保留最高）、kl（保留最低）、dh（丢弃最高）、dl（丢弃最低）
+	Mode string
+	N    int
+}
+
+// Binary 是二元运算，Op 为 '+'、'-' 或 '*'。
+type Binary struct {
+	Op          byte
+	Left, Right Node
+}
+
+func (n *Number) String() string { return strconv.Itoa(n.Value) }
+
+func (r *Roll) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%dd%d", r.Count, r.Sides)
+	if r.Keep != nil {
+		fmt.Fprintf(&b, "%s%d", r.Keep.Mode, r.Keep.N)
+	}
+	if r.Explode {
+		b.WriteByte('!')
+	}
+	return b.String()
+}
+
+func (n *Binary) String() string {
+	return fmt.Sprintf("(%s%c%s)", n.Left, n.Op, n.Right)
+}
+
+// SyntaxError 表示表达式无法解析，Pos 为出错位置（从 0 开始）。
+type SyntaxError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("dice: %s at position %d in %q", e.Msg, e.Pos, e.Expr)
+}
+
+// Parse 把骰子表达式解析为 AST。语法（大小写不敏感，忽略空白）：
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { "*" factor }
+//	factor = roll | number | "(" expr ")"
+//	roll   = [number] "d" (number | "%") [keep] ["!"]
+//	keep   = ("kh" | "kl" | "dh" | "dl" | "k") number
+func Parse(expr string) (Node, error) {
+	p := &parser{src: strings.ToLower(strings.Join(strings.Fields(expr), "")), orig: expr}
+	if p.src == "" {
+		return nil, p.errorf("empty expression")
+	}
+	if len(p.src) > MaxExpressionLength {
+		return nil, p.errorf("expression longer than %d characters", MaxExpressionLength)
+	}
+	n, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return n, nil
+}
+
+type parser struct {
+	src   string
+	orig  string
+	pos   int
+	terms int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Expr: p.orig, Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) expr(depth int) (Node, error) {
+	if depth > MaxDepth {
+		return nil, p.errorf("expression nested deeper than %d", MaxDepth)
+	}
+	left, err := p.term(depth)
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+		right, err := p.term(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: c, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) term(depth int) (Node, error) {
+	left, err := p.factor(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '*' {
+		p.pos++
+		right, err := p.factor(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: '*', Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) factor(depth int) (Node, error) {
+	p.terms++
+	if p.terms > MaxTerms {
+		return nil, p.errorf("more than %d terms", MaxTerms)
+	}
+	if p.peek() == '(' {
+		p.pos++
+		n, err := p.expr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing ')'")
+		}
+		p.pos++
+		return n, nil
+	}
+
+	count, hasCount, err := p.number()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != 'd' {
+		if !hasCount {
+			return nil, p.errorf("expected number, dice or '('")
+		}
+		return &Number{Value: count}, nil
+	}
+	p.pos++
+	if !hasCount {
+		count = 1
+	}
+	return p.roll(count)
+}
+
+func (p *parser) roll(count int) (Node, error) {
+	if count < 1 || count > MaxDicePerTerm {
+		return nil, p.errorf("dice count must be between 1 and %d", MaxDicePerTerm)
+	}
+	r := &Roll{Count: count}
+	if p.peek() == '%' {
+		p.pos++
+		r.Sides = 100
+	} else {
+		sides, ok, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.errorf("expected number of sides")
+		}
+		r.Sides = sides
+	}
+	if r.Sides < 1 || r.Sides > MaxSides {
+		return nil, p.errorf("dice sides must be between 1 and %d", MaxSides)
+	}
+
+	if mode := p.keepMode(); mode != "" {
+		n, ok, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.errorf("expected number after %q", mode)
+		}
+		if n < 1 || n > r.Count {
+			return nil, p.errorf("%s must be between 1 and the dice count %d", mode, r.Count)
+		}
+		r.Keep = &KeepRule{Mode: mode, N: n}
+	}
+	if p.peek() == '!' {
+		p.pos++
+		if r.Sides < 2 {
+			return nil, p.errorf("exploding dice need at least 2 sides")
+		}
+		r.Explode = true
+	}
+	return r, nil
+}
+
+// keepMode 读取保留/丢弃修饰符，"k" 等同于 "kh"。
+func (p *parser) keepMode() string {
+	rest := p.src[p.pos:]
+	for _, m := range []string{"kh", "kl", "dh", "dl"} {
+		if strings.HasPrefix(rest, m) {
+			p.pos += len(m)
+			return m
+		}
+	}
+	if strings.HasPrefix(rest, "k") {
+		p.pos++
+		return "kh"
+	}
+	return ""
+}
+
+// number 读取一个非负整数，ok 表示当前位置是否是数字。
+func (p *parser) number() (n int, ok bool, err error) {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, false, nil
+	}
+	n, err = strconv.Atoi(p.src[start:p.pos])
+	if err != nil || n > 1_000_000 {
+		p.pos = start
+		return 0, false, p.errorf("number too large")
+	}
+	return n, true, nil
+}
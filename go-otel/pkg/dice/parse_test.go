@@ -0,0 +1,86 @@
+package dice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"1d6", "1d6"},
+		{"d20", "1d20"},
+		{"d%", "1d100"},
+		{"4d6kh3+2", "(4d6kh3+2)"},
+		{" 4D6 KH3 + 2 ", "(4d6kh3+2)"},
+		{"2d6k1", "2d6kh1"},
+		{"4d6kl1", "4d6kl1"},
+		{"4d6dh1", "4d6dh1"},
+		{"4d6dl1!", "4d6dl1!"},
+		{"2d20!", "2d20!"},
+		{"1d1", "1d1"},
+		{"42", "42"},
+		{"1-2-3", "((1-2)-3)"},
+		{"1+2*3", "(1+(2*3))"},
+		{"3*(1d4+1)", "(3*(1d4+1))"},
+		{"100d1000", "100d1000"},
+		{"1000000", "1000000"},
+		{strings.Repeat("(", MaxDepth) + "1" + strings.Repeat(")", MaxDepth), "1"},
+		{strings.TrimSuffix(strings.Repeat("1+", MaxTerms), "+"), strings.Repeat("(", MaxTerms-1) + "1" + strings.Repeat("+1)", MaxTerms-1)},
+	}
+	for _, tc := range cases {
+		n, err := Parse(tc.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := n.String(); got != tc.want {
+			t.Errorf("Parse(%q) = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{"", 0, "empty expression"},
+		{"   ", 0, "empty expression"},
+		{"a", 0, "expected number, dice or '('"},
+		{"2d6+", 4, "expected number, dice or '('"},
+		{"1d6)", 3, "unexpected ')'"},
+		{"(1+2", 4, "missing ')'"},
+		{"2d", 2, "expected number of sides"},
+		{"2dx", 2, "expected number of sides"},
+		{"2d6kh", 5, `expected number after "kh"`},
+		{"1d1!", 4, "exploding dice need at least 2 sides"},
+		// 超出限制
+		{strings.Repeat("1", MaxExpressionLength+1), 0, "expression longer than 64 characters"},
+		{"0d6", 2, "dice count must be between 1 and 100"},
+		{"101d6", 4, "dice count must be between 1 and 100"},
+		{"1d0", 3, "dice sides must be between 1 and 1000"},
+		{"1d1001", 6, "dice sides must be between 1 and 1000"},
+		{"2d6kh0", 6, "kh must be between 1 and the dice count 2"},
+		{"2d6dl3", 6, "dl must be between 1 and the dice count 2"},
+		{"1000001", 0, "number too large"},
+		{"1+99999999999999999999", 2, "number too large"},
+		{strings.Repeat("(", MaxDepth+1) + "1" + strings.Repeat(")", MaxDepth+1), MaxDepth + 1, "expression nested deeper than 8"},
+		{strings.TrimSuffix(strings.Repeat("1+", MaxTerms+1), "+"), 2 * MaxTerms, "more than 20 terms"},
+	}
+	for _, tc := range cases {
+		_, err := Parse(tc.expr)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("Parse(%q) error = %v, want *SyntaxError", tc.expr, err)
+			continue
+		}
+		if se.Pos != tc.pos || se.Msg != tc.msg || se.Expr != tc.expr {
+			t.Errorf("Parse(%q) = {Pos: %d, Msg: %q, Expr: %q}, want {Pos: %d, Msg: %q}", tc.expr, se.Pos, se.Msg, se.Expr, tc.pos, tc.msg)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Package fault 实现运行时可配置的故障注入：规则按名称匹配操作（redis、mysql、
+// mcp.calculator、http），按概率注入错误、延迟、超时或 panic，并记录为 span 事件，
+// 用于在演示环境中编排故障演练。
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/random"
+)
+
+// 可注入故障的操作。规则的 target 可以是这些名称，也可以是更具体的子操作，
+// 如 "redis.get"、"mysql.exec"、"http.GET /roll"；target 同时匹配自身及以 "target." 开头的操作。
+const (
+	TargetRedis         = "redis"
+	TargetMySQL         = "mysql"
+	TargetMCPCalculator = "mcp.calculator"
+	TargetHTTP          = "http"
+)
+
+var targetRoots = []string{TargetRedis, TargetMySQL, "mcp", TargetHTTP}
+
+// Kind 是故障类型。
+type Kind string
+
+const (
+	// KindError 直接返回错误
+	KindError Kind = "error"
+	// KindLatency 按分布增加延迟后继续执行
+	KindLatency Kind = "latency"
+	// KindTimeout 阻塞到调用方 deadline 或 Rule.Timeout 后返回超时错误
+	KindTimeout Kind = "timeout"
+	// KindPanic 触发 panic，只在 WithRecover 标记过的 ctx 中生效，其余情况返回 *Error
+	KindPanic Kind = "panic"
+)
+
+const (
+	// 延迟和超时的上限，防止一条规则把请求挂起过久
+	maxDelay       = time.Minute
+	defaultTimeout = 30 * time.Second
+	defaultMessage = "injected fault"
+)
+
+var injections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "fault_injections_total",
+	Help: "The total number of injected faults by rule target and kind",
+}, []string{"target", "kind"})
+
+func init() {
+	prometheus.MustRegister(injections)
+}
+
+// Duration 在 JSON 中以 "150ms"、"2s" 这样的字符串表示。
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"150ms\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Rule 是一条故障注入规则。
+type Rule struct {
+	Id     string `json:"id"`
+	Target string `json:"target"`
+	Kind   Kind   `json:"kind"`
+	// Rate 是每次匹配时注入的概率，取值 (0, 1]
+	Rate float64 `json:"rate"`
+	// Message 是 error 类型返回的错误信息
+	Message string `json:"message,omitempty"`
+	// Latency 是 latency 类型的延迟分布
+	Latency *Latency `json:"latency,omitempty"`
+	// Timeout 是 timeout 类型在调用方没有 deadline 时最多阻塞的时长，默认 30s
+	Timeout Duration `json:"timeout,omitempty"`
+	// TTL 不为零时规则在创建后 TTL 自动失效，演练结束无需手动清理
+	TTL       Duration   `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	// Hits 是规则实际注入的次数
+	Hits int64 `json:"hits"`
+}
+
+// RuleError 表示规则的某个字段不合法。
+type RuleError struct {
+	Field  string
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("fault: %s %s", e.Field, e.Reason)
+}
+
+// Validate 检查规则各字段是否合法。
+func (r *Rule) Validate() error {
+	root, _, _ := strings.Cut(r.Target, ".")
+	known := false
+	for _, t := range targetRoots {
+		known = known || root == t
+	}
+	if !known {
+		return &RuleError{"target", "must be redis, mysql, mcp or http, optionally followed by .<operation>"}
+	}
+	if r.Rate <= 0 || r.Rate > 1 {
+		return &RuleError{"rate", "must be in (0, 1]"}
+	}
+	switch r.Kind {
+	case KindError, KindPanic:
+	case KindLatency:
+		if r.Latency == nil {
+			return &RuleError{"latency", "is required for latency faults"}
+		}
+		if err := r.Latency.validate(); err != nil {
+			return err
+		}
+	case KindTimeout:
+		if r.Timeout < 0 || time.Duration(r.Timeout) > maxDelay {
+			return &RuleError{"timeout", fmt.Sprintf("must be between 0 and %s", maxDelay)}
+		}
+	default:
+		return &RuleError{"kind", "must be error, latency, timeout or panic"}
+	}
+	if r.TTL < 0 {
+		return &RuleError{"ttl", "must not be negative"}
+	}
+	return nil
+}
+
+// matches 判断规则是否作用于操作 op。
+func (r *Rule) matches(op string) bool {
+	return op == r.Target || strings.HasPrefix(op, r.Target+".")
+}
+
+// Error 是注入的错误，timeout 类型可以用 errors.Is(err, context.DeadlineExceeded) 判断。
+type Error struct {
+	RuleId    string
+	Operation string
+	Kind      Kind
+	Msg       string
+	err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fault injected on %s: %s", e.Operation, e.Msg)
+}
+
+func (e *Error) Unwrap() error { return e.err }
+
+type recoverKey struct{}
+
+// WithRecover 标记 ctx 所在的调用链上有 recover（如 gin 的 Recovery 中间件）。
+// 后台 goroutine 中的 panic 没有人 recover，会让整个进程退出，所以 panic 规则只在这样的 ctx 中真正 panic。
+func WithRecover(ctx context.Context) context.Context {
+	return context.WithValue(ctx, recoverKey{}, true)
+}
+
+func canPanic(ctx context.Context) bool {
+	ok, _ := ctx.Value(recoverKey{}).(bool)
+	return ok
+}
+
+type entry struct {
+	rule Rule
+	hits atomic.Int64
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.rule.ExpiresAt != nil && !now.Before(*e.rule.ExpiresAt)
+}
+
+var (
+	mu sync.RWMutex
+	// rules 写时复制，Inject 只需读锁取快照
+	rules []*entry
+)
+
+// Init 从 FAULT_RULES（JSON 数组）加载初始规则。未配置时保留原先的演示行为：
+// DoSomething 有 20% 的概率失败；设为 "[]" 可关闭。
+func Init() {
+	initial := []Rule{{
+		Target:  TargetRedis + ".do_something",
+		Kind:    KindError,
+		Rate:    0.2,
+		Message: "random error for testing",
+	}}
+	if v := os.Getenv("FAULT_RULES"); v != "" {
+		initial = nil
+		if err := json.Unmarshal([]byte(v), &initial); err != nil {
+			panic(fmt.Errorf("parse FAULT_RULES: %w", err))
+		}
+	}
+	Clear()
+	for _, r := range initial {
+		if _, err := Add(r); err != nil {
+			panic(fmt.Errorf("FAULT_RULES: %w", err))
+		}
+	}
+	log.Printf("fault injection: %d rule(s) loaded", len(initial))
+}
+
+// Add 校验并添加一条规则，返回分配了 Id 的规则。
+func Add(r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return Rule{}, err
+	}
+	now := time.Now().UTC()
+	r.Id = uuid.NewString()
+	r.CreatedAt = now
+	r.Hits = 0
+	r.ExpiresAt = nil
+	if r.TTL > 0 {
+		at := now.Add(time.Duration(r.TTL))
+		r.ExpiresAt = &at
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	next := make([]*entry, 0, len(rules)+1)
+	for _, e := range rules {
+		if !e.expired(now) {
+			next = append(next, e)
+		}
+	}
+	rules = append(next, &entry{rule: r})
+	return r, nil
+}
+
+// Remove 删除指定规则，规则不存在时返回 false。
+func Remove(id string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for i, e := range rules {
+		if e.rule.Id == id {
+			next := make([]*entry, 0, len(rules)-1)
+			rules = append(append(next, rules[:i]...), rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear 删除全部规则。
+func Clear() {
+	mu.Lock()
+	defer mu.Unlock()
+	rules = nil
+}
+
+// List 返回当前生效的规则。
+func List() []Rule {
+	now := time.Now()
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]Rule, 0, len(rules))
+	for _, e := range rules {
+		if e.expired(now) {
+			continue
+		}
+		r := e.rule
+		r.Hits = e.hits.Load()
+		out = append(out, r)
+	}
+	return out
+}
+
+// Inject 对操作 op 应用匹配的规则：latency 阻塞后继续，error/timeout 返回 *Error，
+// panic 在 WithRecover 标记过的 ctx 中直接 panic，否则返回 *Error。
+// 是否注入由请求的 random.Rand 决定，同一个种子可以重放同样的故障。
+func Inject(ctx context.Context, op string) error {
+	mu.RLock()
+	snapshot := rules
+	mu.RUnlock()
+	if len(snapshot) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var rng *random.Rand
+	for _, e := range snapshot {
+		if e.expired(now) || !e.rule.matches(op) {
+			continue
+		}
+		if rng == nil {
+			rng = random.FromContext(ctx)
+		}
+		if rng.Float64() >= e.rule.Rate {
+			continue
+		}
+		e.hits.Add(1)
+		injections.WithLabelValues(e.rule.Target, string(e.rule.Kind)).Inc()
+		if err := e.apply(ctx, op, rng); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *entry) apply(ctx context.Context, op string, rng *random.Rand) error {
+	r := &e.rule
+	span := gotrace.SpanFromContext(ctx)
+	attrs := []attribute.KeyValue{
+		attribute.String("fault.rule_id", r.Id),
+		attribute.String("fault.target", r.Target),
+		attribute.String("fault.operation", op),
+		attribute.String("fault.kind", string(r.Kind)),
+	}
+
+	switch r.Kind {
+	case KindLatency:
+		d := r.Latency.sample(rng)
+		span.AddEvent("fault.injected", gotrace.WithAttributes(append(attrs,
+			attribute.Float64("fault.latency_ms", float64(d)/float64(time.Millisecond)))...))
+		return sleep(ctx, d)
+
+	case KindTimeout:
+		span.AddEvent("fault.injected", gotrace.WithAttributes(attrs...))
+		wait := time.Duration(r.Timeout)
+		if wait == 0 {
+			wait = defaultTimeout
+		}
+		_ = sleep(ctx, wait)
+		return &Error{RuleId: r.Id, Operation: op, Kind: r.Kind, Msg: "operation timed out", err: context.DeadlineExceeded}
+
+	case KindPanic:
+		if !canPanic(ctx) {
+			span.AddEvent("fault.injected", gotrace.WithAttributes(append(attrs, attribute.Bool("fault.panic_as_error", true))...))
+			return &Error{RuleId: r.Id, Operation: op, Kind: r.Kind, Msg: "injected panic (returned as error outside a request)"}
+		}
+		span.AddEvent("fault.injected", gotrace.WithAttributes(attrs...))
+		panic(fmt.Sprintf("fault: injected panic on %s (rule %s)", op, r.Id))
+	}
+
+	msg := r.Message
+	if msg == "" {
+		msg = defaultMessage
+	}
+	span.AddEvent("fault.injected", gotrace.WithAttributes(append(attrs, attribute.String("fault.message", msg))...))
+	return &Error{RuleId: r.Id, Operation: op, Kind: r.Kind, Msg: msg}
+}
+
+// sleep 阻塞 d，ctx 先结束时返回 ctx.Err()。
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
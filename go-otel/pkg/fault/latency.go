@@ -0,0 +1,80 @@
+package fault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/random"
+)
+
+// Latency 描述注入延迟的分布：
+//
+//	fixed        固定为 Mean
+//	uniform      在 [Min, Max] 之间均匀分布
+//	normal       均值 Mean、标准差 Stddev 的正态分布
+//	exponential  均值 Mean 的指数分布，适合模拟长尾
+//
+// Min/Max 不为零时同时作为采样结果的上下限。
+type Latency struct {
+	Distribution string   `json:"distribution"`
+	Min          Duration `json:"min,omitempty"`
+	Max          Duration `json:"max,omitempty"`
+	Mean         Duration `json:"mean,omitempty"`
+	Stddev       Duration `json:"stddev,omitempty"`
+}
+
+func (l *Latency) validate() error {
+	if l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.Stddev < 0 {
+		return &RuleError{"latency", "durations must not be negative"}
+	}
+	if time.Duration(l.Max) > maxDelay || time.Duration(l.Mean) > maxDelay {
+		return &RuleError{"latency", fmt.Sprintf("must not exceed %s", maxDelay)}
+	}
+	if l.Max != 0 && l.Min > l.Max {
+		return &RuleError{"latency.min", "must not be greater than max"}
+	}
+	switch l.Distribution {
+	case "fixed", "exponential":
+		if l.Mean == 0 {
+			return &RuleError{"latency.mean", "is required for " + l.Distribution + " latency"}
+		}
+	case "uniform":
+		if l.Max == 0 {
+			return &RuleError{"latency.max", "is required for uniform latency"}
+		}
+	case "normal":
+		if l.Mean == 0 || l.Stddev == 0 {
+			return &RuleError{"latency", "mean and stddev are required for normal latency"}
+		}
+	default:
+		return &RuleError{"latency.distribution", "must be fixed, uniform, normal or exponential"}
+	}
+	return nil
+}
+
+// sample 按分布采样一个延迟。
+func (l *Latency) sample(rng *random.Rand) time.Duration {
+	var d float64
+	switch l.Distribution {
+	case "fixed":
+		d = float64(l.Mean)
+	case "uniform":
+		d = float64(l.Min) + rng.Float64()*float64(l.Max-l.Min)
+	case "normal":
+		d = float64(l.Mean) + rng.NormFloat64()*float64(l.Stddev)
+	case "exponential":
+		d = rng.ExpFloat64() * float64(l.Mean)
+	}
+
+	if d < float64(l.Min) {
+		d = float64(l.Min)
+	}
+	upper := float64(maxDelay)
+	if l.Max != 0 {
+		upper = float64(l.Max)
+	}
+	if d > upper {
+		d = upper
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,149 @@
+package fault
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// WrapDriver 包装 database/sql 驱动，在查询、执行、预编译、开启事务和 ping 之前注入故障，
+// 操作名为 op + ".query"、".exec"、".prepare"、".begin"、".ping"。
+// 与 otelsql 一起使用时应由 otelsql 包在外层，注入的故障会记录在对应的数据库 span 上。
+func WrapDriver(d driver.Driver, op string) driver.Driver {
+	return &faultDriver{Driver: d, op: op}
+}
+
+type faultDriver struct {
+	driver.Driver
+	op string
+}
+
+func (d *faultDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{Conn: c, op: d.op}, nil
+}
+
+type faultConn struct {
+	driver.Conn
+	op string
+}
+
+func (c *faultConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := Inject(ctx, c.op+".prepare"); err != nil {
+		return nil, err
+	}
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &faultStmt{Stmt: s, op: c.op}, nil
+}
+
+func (c *faultConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := Inject(ctx, c.op+".begin"); err != nil {
+		return nil, err
+	}
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	// 底层驱动不支持 BeginTx 时的回退
+	return c.Conn.Begin()
+}
+
+func (c *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := Inject(ctx, c.op+".query"); err != nil {
+		return nil, err
+	}
+	return qc.QueryContext(ctx, query, args)
+}
+
+func (c *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := Inject(ctx, c.op+".exec"); err != nil {
+		return nil, err
+	}
+	return ec.ExecContext(ctx, query, args)
+}
+
+func (c *faultConn) Ping(ctx context.Context) error {
+	if err := Inject(ctx, c.op+".ping"); err != nil {
+		return err
+	}
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *faultConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *faultConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *faultConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type faultStmt struct {
+	driver.Stmt
+	op string
+}
+
+func (s *faultStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := Inject(ctx, s.op+".exec"); err != nil {
+		return nil, err
+	}
+	if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return ec.ExecContext(ctx, args)
+	}
+	// 底层驱动不支持 ExecContext 时的回退
+	return s.Stmt.Exec(namedValues(args))
+}
+
+func (s *faultStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := Inject(ctx, s.op+".query"); err != nil {
+		return nil, err
+	}
+	if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return qc.QueryContext(ctx, args)
+	}
+	// 底层驱动不支持 QueryContext 时的回退
+	return s.Stmt.Query(namedValues(args))
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	return values
+}
@@ -0,0 +1,231 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+// BreakerState 是熔断器的状态。
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+var breakerStates = []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// ErrCircuitOpen 表示熔断器处于打开状态，调用未发出。
+var ErrCircuitOpen = errors.New("mcp: circuit breaker is open")
+
+var (
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_circuit_breaker_state",
+		Help: "The state of the per-tool MCP circuit breaker, 1 for the current state",
+	}, []string{"tool", "state"})
+	breakerTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_circuit_breaker_transitions_total",
+		Help: "The total number of MCP circuit breaker state transitions",
+	}, []string{"tool", "from", "to"})
+	breakerRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_circuit_breaker_rejections_total",
+		Help: "The total number of MCP tool calls rejected by an open circuit breaker",
+	}, []string{"tool"})
+)
+
+func init() {
+	prometheus.MustRegister(breakerState, breakerTransitions, breakerRejections)
+}
+
+// BreakerConfig 是熔断器的阈值。
+type BreakerConfig struct {
+	// FailureThreshold 是关闭状态下连续失败多少次后打开
+	FailureThreshold int
+	// OpenTimeout 是打开后多久进入半开状态
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls 是半开状态下同时放行的试探调用数，全部成功后关闭
+	HalfOpenMaxCalls int
+}
+
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// breakerConfigFromEnv 读取 MCP_BREAKER_FAILURE_THRESHOLD（默认 5）、MCP_BREAKER_OPEN_TIMEOUT（默认 10s）
+// 和 MCP_BREAKER_HALF_OPEN_MAX_CALLS（默认 1）。
+func breakerConfigFromEnv() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: envInt("MCP_BREAKER_FAILURE_THRESHOLD", 5),
+		OpenTimeout:      envDuration("MCP_BREAKER_OPEN_TIMEOUT", 10*time.Second),
+		HalfOpenMaxCalls: envInt("MCP_BREAKER_HALF_OPEN_MAX_CALLS", 1),
+	}
+}
+
+// Breaker 是一个工具的熔断器。
+type Breaker struct {
+	tool string
+	conf BreakerConfig
+	// now 返回当前时间，测试中可替换
+	now func() time.Time
+
+	mu    sync.Mutex
+	state BreakerState
+	// generation 在每次状态切换时递增，用来识别切换之前放行的调用
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	// 半开状态下已放行和已成功的试探调用数
+	probes    int
+	successes int
+}
+
+// NewBreaker 返回工具 tool 的熔断器，初始为关闭状态。
+func NewBreaker(tool string, conf BreakerConfig) *Breaker {
+	b := &Breaker{tool: tool, conf: conf, now: time.Now}
+	b.publishState()
+	return b
+}
+
+// State 返回当前状态，打开超过 OpenTimeout 时视为半开。
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.conf.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Allow 判断能否发出调用。允许时返回的 done 必须调用一次：success 表示调用是否成功，
+// counted 为 false 时（如调用方自己取消）结果不计入统计。
+func (b *Breaker) Allow(ctx context.Context) (done func(success, counted bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.conf.OpenTimeout {
+		b.transition(ctx, BreakerHalfOpen)
+	}
+	switch b.state {
+	case BreakerOpen:
+		breakerRejections.WithLabelValues(b.tool).Inc()
+		return nil, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.conf.HalfOpenMaxCalls {
+			breakerRejections.WithLabelValues(b.tool).Inc()
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+	}
+
+	gen := b.generation
+	var once sync.Once
+	return func(success, counted bool) {
+		once.Do(func() { b.record(ctx, gen, success, counted) })
+	}, nil
+}
+
+// record 统计放行时处于第 gen 代的调用结果。状态已切换过的调用结果被忽略：
+// 打开之前发出、之后才失败的调用不能让半开的熔断器重新打开，也不能释放不属于它的试探名额。
+func (b *Breaker) record(ctx context.Context, gen uint64, success, counted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if !counted {
+			return
+		}
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.conf.FailureThreshold {
+			b.transition(ctx, BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if !counted {
+			// 释放试探名额，让下一个调用重新试探
+			b.probes--
+			return
+		}
+		if !success {
+			b.transition(ctx, BreakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.conf.HalfOpenMaxCalls {
+			b.transition(ctx, BreakerClosed)
+		}
+	}
+}
+
+// transition 在持有 mu 时切换状态，并在当前 span 上记录事件。
+func (b *Breaker) transition(ctx context.Context, to BreakerState) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if to == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	breakerTransitions.WithLabelValues(b.tool, from.String(), to.String()).Inc()
+	b.publishState()
+	gotrace.SpanFromContext(ctx).AddEvent("mcp.circuit_breaker.state_change", gotrace.WithAttributes(
+		attribute.String("mcp.tool", b.tool),
+		attribute.String("mcp.circuit_breaker.from", from.String()),
+		attribute.String("mcp.circuit_breaker.to", to.String()),
+	))
+}
+
+func (b *Breaker) publishState() {
+	for _, s := range breakerStates {
+		v := 0.0
+		if s == b.state {
+			v = 1
+		}
+		breakerState.WithLabelValues(b.tool, s.String()).Set(v)
+	}
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*Breaker{}
+)
+
+// breakerFor 返回工具 tool 的熔断器，首次使用时按环境变量配置创建。
+func breakerFor(tool string) *Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[tool]
+	if !ok {
+		b = NewBreaker(tool, breakerConfigFromEnv())
+		breakers[tool] = b
+	}
+	return b
+}
@@ -0,0 +1,129 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBreaker(t *testing.T, conf BreakerConfig) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := NewBreaker(t.Name(), conf)
+	b.now = clock.now
+	return b, clock
+}
+
+// allow 调用 Allow 并要求放行
+func allow(t *testing.T, b *Breaker) func(success, counted bool) {
+	t.Helper()
+	done, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("Allow in state %s: %v", b.State(), err)
+	}
+	return done
+}
+
+func assertRejected(t *testing.T, b *Breaker) {
+	t.Helper()
+	if _, err := b.Allow(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow in state %s: err = %v, want ErrCircuitOpen", b.State(), err)
+	}
+}
+
+func assertState(t *testing.T, b *Breaker, want BreakerState) {
+	t.Helper()
+	if got := b.State(); got != want {
+		t.Fatalf("state = %s, want %s", got, want)
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	b, clock := newTestBreaker(t, BreakerConfig{FailureThreshold: 3, OpenTimeout: 10 * time.Second, HalfOpenMaxCalls: 2})
+
+	// 关闭：成功会清零连续失败次数，未计入的结果不影响统计
+	allow(t, b)(false, true)
+	allow(t, b)(false, true)
+	allow(t, b)(true, true)
+	allow(t, b)(false, true)
+	allow(t, b)(false, true)
+	allow(t, b)(false, false)
+	assertState(t, b, BreakerClosed)
+	allow(t, b)(false, true)
+	assertState(t, b, BreakerOpen)
+
+	// 打开：OpenTimeout 之内拒绝所有调用
+	assertRejected(t, b)
+	clock.advance(10*time.Second - time.Millisecond)
+	assertState(t, b, BreakerOpen)
+	assertRejected(t, b)
+
+	// 半开：最多放行 HalfOpenMaxCalls 个试探调用
+	clock.advance(time.Millisecond)
+	assertState(t, b, BreakerHalfOpen)
+	p1 := allow(t, b)
+	p2 := allow(t, b)
+	assertRejected(t, b)
+
+	// 未计入的试探释放名额
+	p2(false, false)
+	p3 := allow(t, b)
+	assertRejected(t, b)
+
+	// 全部试探成功后关闭
+	p1(true, true)
+	assertState(t, b, BreakerHalfOpen)
+	p3(true, true)
+	assertState(t, b, BreakerClosed)
+	allow(t, b)(true, true)
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b, clock := newTestBreaker(t, BreakerConfig{FailureThreshold: 1, OpenTimeout: 5 * time.Second, HalfOpenMaxCalls: 1})
+
+	allow(t, b)(false, true)
+	assertState(t, b, BreakerOpen)
+	clock.advance(5 * time.Second)
+	allow(t, b)(false, true)
+	assertState(t, b, BreakerOpen)
+
+	// 重新打开时从失败的时刻开始计时
+	clock.advance(4 * time.Second)
+	assertRejected(t, b)
+	clock.advance(time.Second)
+	assertState(t, b, BreakerHalfOpen)
+}
+
+// 打开之前放行、状态切换之后才返回的调用结果不能影响当前状态
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	b, clock := newTestBreaker(t, BreakerConfig{FailureThreshold: 1, OpenTimeout: 5 * time.Second, HalfOpenMaxCalls: 1})
+
+	first := allow(t, b)
+	staleFailure := allow(t, b)
+	staleCancel := allow(t, b)
+	first(false, true)
+	assertState(t, b, BreakerOpen)
+
+	clock.advance(5 * time.Second)
+	probe := allow(t, b)
+	gen := b.generation
+
+	// 迟到的失败不能让半开的熔断器重新打开，迟到的取消也不能释放试探名额
+	staleFailure(false, true)
+	staleCancel(false, false)
+	assertState(t, b, BreakerHalfOpen)
+	if b.generation != gen || b.probes != 1 {
+		t.Fatalf("generation = %d, probes = %d after stale results, want %d, 1", b.generation, b.probes, gen)
+	}
+	assertRejected(t, b)
+
+	probe(true, true)
+	assertState(t, b, BreakerClosed)
+}
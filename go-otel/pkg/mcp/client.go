@@ -3,136 +3,254 @@ package mcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/mcp/meta"
 )
 
+// shared 是全局共享的托管客户端。
+var shared = NewManager(TransportConfigFromEnv())
+
+// StartHealthMonitor 启动共享客户端的健康检查和自动重连，ctx 取消后关闭连接。
+func StartHealthMonitor(ctx context.Context) {
+	shared.StartHealthMonitor(ctx)
+}
+
+// ErrBudgetExhausted 表示请求分配给 MCP 调用的时间预算已经用完，调用未发出。
+var ErrBudgetExhausted = errors.New("mcp: call budget exhausted")
+
+var fallbackCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mcp_fallbacks_total",
+	Help: "The total number of MCP tool calls answered by a fallback, by tool and reason",
+}, []string{"tool", "reason"})
+
+func init() {
+	prometheus.MustRegister(fallbackCalls)
+}
+
+// callTimeout 是单次工具调用的最长等待时间，可通过 MCP_CALL_TIMEOUT 配置。
+var callTimeout = envDuration("MCP_CALL_TIMEOUT", time.Second)
+
+type budgetKey struct{}
+
+// WithBudget 为 ctx 中的所有 MCP 调用设置总的时间预算 d，每次调用的超时不超过剩余预算。
+// ctx 已有更早到期的预算时保持不变。
+func WithBudget(ctx context.Context, d time.Duration) context.Context {
+	deadline := time.Now().Add(d)
+	if prev, ok := ctx.Value(budgetKey{}).(time.Time); ok && prev.Before(deadline) {
+		return ctx
+	}
+	return context.WithValue(ctx, budgetKey{}, deadline)
+}
+
+// timeoutFor 返回本次调用的超时：callTimeout 与剩余预算中较小的一个。
+func timeoutFor(ctx context.Context) (time.Duration, error) {
+	timeout := callTimeout
+	if deadline, ok := ctx.Value(budgetKey{}).(time.Time); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, ErrBudgetExhausted
+		}
+		timeout = min(timeout, remaining)
+	}
+	return timeout, nil
+}
+
+// FallbackFunc 在工具调用失败、超时或被熔断时提供替代结果，cause 是失败原因。
+type FallbackFunc func(ctx context.Context, args map[string]any, cause error) (*mcp.CallToolResult, error)
+
 var (
-	sharedMCPClient *client.Client
-	mcpInitOnce     sync.Once
+	fallbacksMu sync.RWMutex
+	fallbacks   = map[string]FallbackFunc{}
 )
 
-// getMCPServerURL 返回 MCP 服务器的 SSE 地址。
-func getMCPServerURL() string {
-	port := os.Getenv("MCP_SSE_PORT")
-	if port == "" {
-		port = "8184"
+// SetFallback 注册工具 tool 的降级逻辑，fn 为 nil 时取消注册。
+func SetFallback(tool string, fn FallbackFunc) {
+	fallbacksMu.Lock()
+	defer fallbacksMu.Unlock()
+	if fn == nil {
+		delete(fallbacks, tool)
+		return
 	}
-	return fmt.Sprintf("http://localhost:%s", port)
+	fallbacks[tool] = fn
 }
 
-// getSharedMCPClient 返回全局唯一的 MCP 客户端实例，必要时自动初始化。
-func getSharedMCPClient() (*client.Client, error) {
-	var err error
-	mcpInitOnce.Do(func() {
-		sharedMCPClient, err = client.NewSSEMCPClient(getMCPServerURL() + "/sse")
-		if err != nil {
-			return
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err = sharedMCPClient.Start(ctx); err != nil {
-			sharedMCPClient = nil
-			return
-		}
-		// Initialize
-		initRequest := mcp.InitializeRequest{}
-		initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
-		initRequest.Params.ClientInfo = mcp.Implementation{
-			Name:    "go-otel-demo-server",
-			Version: "1.0.0",
-		}
-		_, err = sharedMCPClient.Initialize(ctx, initRequest)
+// SetCalculatorFallback 注册 calculator 工具的降级逻辑，参数与 CallCalculatorTool 相同。
+func SetCalculatorFallback(fn func(ctx context.Context, operation string, x, y float64) (float64, error)) {
+	SetFallback("calculator", func(ctx context.Context, args map[string]any, _ error) (*mcp.CallToolResult, error) {
+		operation, _ := args["operation"].(string)
+		x, _ := args["x"].(float64)
+		y, _ := args["y"].(float64)
+		v, err := fn(ctx, operation, x, y)
 		if err != nil {
-			sharedMCPClient = nil
+			return nil, err
 		}
+		return mcp.NewToolResultText(strconv.FormatFloat(v, 'f', -1, 64)), nil
 	})
-	return sharedMCPClient, err
 }
 
-// CallCalculatorTool 调用 MCP 服务器的 calculator 工具进行加法等计算。
-// operation 支持 "add"、"sub"、"mul"、"div" 等。
-// 返回计算结果或错误。
-func CallCalculatorTool(ctx context.Context, operation string, x, y float64) (float64, error) {
-	ctx, span := otel.Tracer("roll").Start(ctx, "CallCalculatorTool")
-	defer span.End()
+// fallbackReason 把失败原因归类为指标的 reason 标签。
+func fallbackReason(err error) string {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrBudgetExhausted):
+		return "budget_exhausted"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	}
+	return "error"
+}
 
-	span.SetAttributes(
-		attribute.String("mcp.tool", "calculator"),
-		attribute.String("mcp.operation", operation),
-		attribute.Float64("mcp.x", x),
-		attribute.Float64("mcp.y", y),
-	)
+// callTool 在熔断器和超时预算的保护下调用工具，失败时交给已注册的降级逻辑。
+func callTool(ctx context.Context, name string, args map[string]any) (*mcp.CallToolResult, error) {
+	result, err := guardedCall(ctx, name, args)
+	if err == nil {
+		return result, nil
+	}
+	return fallback(ctx, name, args, err)
+}
 
-	cli, err := getSharedMCPClient()
-	if err != nil || cli == nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to initialize MCP cli")
-		return 0, fmt.Errorf("MCP 客户端初始化失败: %w", err)
+// fallback 用已注册的降级逻辑替代失败的调用，没有注册或调用方已取消时返回 err。
+func fallback(ctx context.Context, name string, args map[string]any, err error) (*mcp.CallToolResult, error) {
+	fallbacksMu.RLock()
+	fb := fallbacks[name]
+	fallbacksMu.RUnlock()
+	if fb == nil || ctx.Err() != nil {
+		return nil, err
+	}
+	reason := fallbackReason(err)
+	fallbackCalls.WithLabelValues(name, reason).Inc()
+	span := gotrace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Bool("mcp.fallback", true))
+	span.AddEvent("mcp.fallback", gotrace.WithAttributes(
+		attribute.String("mcp.tool", name),
+		attribute.String("mcp.fallback.reason", reason),
+		attribute.String("mcp.fallback.cause", err.Error()),
+	))
+	return fb(ctx, args, err)
+}
+
+func guardedCall(ctx context.Context, name string, args map[string]any) (*mcp.CallToolResult, error) {
+	span := gotrace.SpanFromContext(ctx)
+	breaker := breakerFor(name)
+	span.SetAttributes(attribute.String("mcp.circuit_breaker.state", breaker.State().String()))
+	done, err := breaker.Allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// done 只生效一次，这里保证 panic 或提前返回时也会释放半开状态的试探名额
+	defer done(false, false)
+	timeout, err := timeoutFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("mcp.timeout_ms", timeout.Milliseconds()))
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result, err := doCall(cctx, name, args)
+	// 调用方自己取消时不计入熔断统计
+	done(err == nil, err == nil || ctx.Err() == nil)
+	if err != nil && cctx.Err() != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("MCP 工具 %s 调用超时（%s）: %w", name, timeout, context.DeadlineExceeded)
+	}
+	return result, err
+}
+
+func doCall(ctx context.Context, name string, args map[string]any) (*mcp.CallToolResult, error) {
+	if err := fault.Inject(ctx, "mcp."+name); err != nil {
+		return nil, err
+	}
+
+	cli, err := shared.Client(ctx)
+	gotrace.SpanFromContext(ctx).SetAttributes(shared.Attributes()...)
+	if err != nil {
+		return nil, fmt.Errorf("MCP 客户端初始化失败: %w", err)
 	}
 
+	ctx, span := otel.Tracer("roll").Start(ctx, "tools/call "+name, gotrace.WithSpanKind(gotrace.SpanKindClient),
+		gotrace.WithAttributes(
+			attribute.String("rpc.system", "jsonrpc"),
+			attribute.String("rpc.method", "tools/call"),
+			attribute.String("mcp.tool", name),
+		))
+	defer span.End()
+
 	toolReq := mcp.CallToolRequest{
 		Params: mcp.CallToolParams{
-			Name: "calculator",
-			Arguments: map[string]interface{}{
-				"operation": operation,
-				"x":         x,
-				"y":         y,
-			},
+			Name:      name,
+			Arguments: args,
 		},
 	}
-
-	startTime := time.Now()
+	// 通过 _meta 传递 trace context，MCP 服务器中的工具 span 会成为这个 span 的子 span
+	toolReq.Params.Meta = meta.Inject(ctx, toolReq.Params.Meta)
 	result, err := cli.CallTool(ctx, toolReq)
+	if isTransportError(err) {
+		shared.MarkFailed(cli, "transport_error", err)
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "MCP tool call failed")
-		return 0, fmt.Errorf("MCP 工具调用失败: %w", err)
+		return nil, fmt.Errorf("MCP 工具调用失败: %w", err)
 	}
-	duration := time.Since(startTime)
-	span.SetAttributes(attribute.Float64("mcp.duration_ms", float64(duration.Nanoseconds())/1e6))
-
-	if len(result.Content) == 0 {
-		return 0, fmt.Errorf("MCP 响应内容为空")
+	if result.IsError {
+		span.SetStatus(codes.Error, "tool returned an error")
 	}
+	return result, nil
+}
 
-	var resultStr string
-	if textContent, ok := mcp.AsTextContent(result.Content[0]); ok {
-		resultStr = textContent.Text
-	} else {
-		resultStr = fmt.Sprintf("%v", result.Content[0])
-	}
+// calculatorArgs 是 calculator 工具的参数。
+type calculatorArgs struct {
+	Operation string  `json:"operation"`
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+}
 
-	if resultStr == "" {
-		return 0, fmt.Errorf("未找到文本结果")
-	}
+// CallCalculatorTool 调用 MCP 服务器的 calculator 工具进行加法等计算。
+// operation 支持 "add"、"subtract"、"multiply"、"divide"。
+// 调用受熔断器和 WithBudget 设置的时间预算保护，失败时使用 SetCalculatorFallback 注册的降级逻辑。
+func CallCalculatorTool(ctx context.Context, operation string, x, y float64) (float64, error) {
+	ctx, span := otel.Tracer("roll").Start(ctx, "CallCalculatorTool")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("mcp.tool", "calculator"),
+		attribute.String("mcp.operation", operation),
+		attribute.Float64("mcp.x", x),
+		attribute.Float64("mcp.y", y),
+	)
 
-	calculationResult, err := strconv.ParseFloat(resultStr, 64)
+	startTime := time.Now()
+	result, err := CallTool[calculatorArgs, float64](ctx, "calculator", calculatorArgs{Operation: operation, X: x, Y: y})
 	if err != nil {
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "parse result failed")
-		return 0, fmt.Errorf("解析计算结果失败: %w", err)
+		span.SetStatus(codes.Error, "MCP tool call failed")
+		return 0, err
 	}
-
-	span.SetAttributes(attribute.Float64("mcp.result", calculationResult))
-	return calculationResult, nil
+	duration := time.Since(startTime)
+	span.SetAttributes(
+		attribute.Float64("mcp.duration_ms", float64(duration.Nanoseconds())/1e6),
+		attribute.Float64("mcp.result", result),
+	)
+	return result, nil
 }
 
-// CloseSharedMCPClient 关闭全局 MCP 客户端连接。
+// CloseSharedMCPClient 关闭全局 MCP 客户端连接，下次调用时会重新连接。
 func CloseSharedMCPClient() error {
-	if sharedMCPClient != nil {
-		err := sharedMCPClient.Close()
-		sharedMCPClient = nil
-		return err
-	}
-	return nil
+	return shared.Close()
 }
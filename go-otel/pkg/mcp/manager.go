@@ -0,0 +1,350 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+// State 是托管客户端的连接状态。
+type State int
+
+const (
+	StateIdle State = iota
+	StateConnecting
+	StateReady
+	StateBackoff
+)
+
+var states = []State{StateIdle, StateConnecting, StateReady, StateBackoff}
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateReady:
+		return "ready"
+	case StateBackoff:
+		return "backoff"
+	}
+	return "idle"
+}
+
+// ErrUnavailable 表示 MCP 服务器在重连退避期内，调用方应直接走降级逻辑。
+var ErrUnavailable = errors.New("mcp: server unavailable")
+
+var (
+	clientState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_client_state",
+		Help: "The connection state of the MCP client, 1 for the current state",
+	}, []string{"state"})
+	clientConnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_client_connects_total",
+		Help: "The total number of MCP connect attempts by result",
+	}, []string{"result"})
+	clientDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_client_disconnects_total",
+		Help: "The total number of times a ready MCP connection was dropped, by reason",
+	}, []string{"reason"})
+	clientPings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_client_health_pings_total",
+		Help: "The total number of MCP health check pings by result",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(clientState, clientConnects, clientDisconnects, clientPings)
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// Manager 管理一个 MCP 客户端连接：首次使用时才连接，连接失败或健康检查失败后
+// 按指数退避重连并重新 initialize，退避期内的调用立即返回 ErrUnavailable。
+type Manager struct {
+	conf TransportConfig
+
+	ConnectTimeout time.Duration
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	HealthInterval time.Duration
+	PingTimeout    time.Duration
+
+	// connectMu 保证同一时间只有一个连接过程，mu 保护下面的状态
+	connectMu   sync.Mutex
+	mu          sync.Mutex
+	cli         *client.Client
+	state       State
+	used        bool
+	failures    int
+	lastErr     error
+	nextAttempt time.Time
+	// generation 在每次连接成功后加一，用于区分 span 和日志属于哪一次连接
+	generation int64
+
+	// tools 缓存 ListTools 的结果，连接变化、超过 ToolsTTL 或收到 tools/list_changed 通知后失效
+	ToolsTTL     time.Duration
+	tools        map[string]mcp.Tool
+	toolsGen     int64
+	toolsFetched time.Time
+}
+
+// NewManager 返回按 conf 连接 MCP 服务器的 Manager，超时和退避可通过 MCP_CONNECT_TIMEOUT、
+// MCP_RECONNECT_MIN_BACKOFF、MCP_RECONNECT_MAX_BACKOFF、MCP_HEALTH_INTERVAL 和 MCP_PING_TIMEOUT 配置，
+// 工具列表的缓存时间可通过 MCP_TOOLS_CACHE_TTL 配置。
+func NewManager(conf TransportConfig) *Manager {
+	return &Manager{
+		conf:           conf,
+		ConnectTimeout: envDuration("MCP_CONNECT_TIMEOUT", 5*time.Second),
+		MinBackoff:     envDuration("MCP_RECONNECT_MIN_BACKOFF", 500*time.Millisecond),
+		MaxBackoff:     envDuration("MCP_RECONNECT_MAX_BACKOFF", 30*time.Second),
+		HealthInterval: envDuration("MCP_HEALTH_INTERVAL", 15*time.Second),
+		PingTimeout:    envDuration("MCP_PING_TIMEOUT", 2*time.Second),
+		ToolsTTL:       envDuration("MCP_TOOLS_CACHE_TTL", 5*time.Minute),
+	}
+}
+
+// setState 在持有 mu 时调用。
+func (m *Manager) setState(s State) {
+	m.state = s
+	for _, st := range states {
+		v := 0.0
+		if st == s {
+			v = 1
+		}
+		clientState.WithLabelValues(st.String()).Set(v)
+	}
+}
+
+// Attributes 返回描述当前连接状态的 span 属性。
+func (m *Manager) Attributes() []attribute.KeyValue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return []attribute.KeyValue{
+		attribute.String("mcp.transport", m.conf.Transport),
+		attribute.String("mcp.client.state", m.state.String()),
+		attribute.Int64("mcp.client.generation", m.generation),
+		attribute.Int("mcp.client.consecutive_failures", m.failures),
+	}
+}
+
+// Client 返回已初始化的客户端，尚未连接或连接已断开时同步连接一次。
+func (m *Manager) Client(ctx context.Context) (*client.Client, error) {
+	m.mu.Lock()
+	m.used = true
+	if m.state == StateReady {
+		cli := m.cli
+		m.mu.Unlock()
+		return cli, nil
+	}
+	if wait := time.Until(m.nextAttempt); m.state == StateBackoff && wait > 0 {
+		err := m.lastErr
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w, retry in %s: %v", ErrUnavailable, wait.Round(time.Millisecond), err)
+	}
+	m.mu.Unlock()
+	return m.connect(ctx)
+}
+
+func (m *Manager) connect(ctx context.Context) (*client.Client, error) {
+	m.connectMu.Lock()
+	defer m.connectMu.Unlock()
+
+	// 等锁期间其他调用方可能已经连接成功或失败
+	m.mu.Lock()
+	switch {
+	case m.state == StateReady:
+		cli := m.cli
+		m.mu.Unlock()
+		return cli, nil
+	case m.state == StateBackoff && time.Now().Before(m.nextAttempt):
+		err := m.lastErr
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	m.setState(StateConnecting)
+	attempt := m.failures + 1
+	m.mu.Unlock()
+
+	ctx, span := otel.Tracer("roll").Start(ctx, "mcp.connect", gotrace.WithAttributes(
+		attribute.String("mcp.transport", m.conf.Transport),
+		attribute.String("mcp.server.target", m.conf.Target()),
+		attribute.Int("mcp.connect.attempt", attempt),
+	))
+	defer span.End()
+
+	cli, err := m.dial(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		clientConnects.WithLabelValues("error").Inc()
+		m.failures++
+		m.lastErr = err
+		backoff := m.backoff(m.failures)
+		m.nextAttempt = time.Now().Add(backoff)
+		m.setState(StateBackoff)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "connect failed")
+		span.SetAttributes(attribute.Int64("mcp.reconnect.backoff_ms", backoff.Milliseconds()))
+		log.Printf("mcp: connect to %s via %s failed (attempt %d), retry in %s: %v", m.conf.Target(), m.conf.Transport, attempt, backoff, err)
+		return nil, err
+	}
+	clientConnects.WithLabelValues("success").Inc()
+	m.cli = cli
+	m.failures = 0
+	m.lastErr = nil
+	m.generation++
+	m.setState(StateReady)
+	span.SetAttributes(attribute.Int64("mcp.client.generation", m.generation))
+	if attempt > 1 {
+		log.Printf("mcp: reconnected to %s after %d attempts", m.conf.Target(), attempt)
+	}
+	return cli, nil
+}
+
+// dial 建立连接并完成 initialize。SSE 流和 stdio 子进程的生命周期跟随 Start 的 context，
+// 所以 Start 使用独立的 context，连接超时只作用于等待过程。
+func (m *Manager) dial(ctx context.Context) (*client.Client, error) {
+	cli, err := m.conf.newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.ConnectTimeout)
+	defer cancel()
+
+	started := make(chan error, 1)
+	go func() { started <- cli.Start(context.Background()) }()
+	select {
+	case err = <-started:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("start: %w", err)
+	}
+	forwardStderr(cli)
+
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{
+		Name:    "go-otel-demo-server",
+		Version: "1.0.0",
+	}
+	if _, err := cli.Initialize(ctx, initRequest); err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	cli.OnNotification(func(n mcp.JSONRPCNotification) {
+		if n.Method == methodToolsListChanged {
+			m.invalidateTools()
+		}
+	})
+	return cli, nil
+}
+
+// backoff 返回第 failures 次失败后的等待时间，第一次失败后立即重试。
+func (m *Manager) backoff(failures int) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+	d := m.MaxBackoff
+	if failures < 32 {
+		d = min(m.MinBackoff<<(failures-2), m.MaxBackoff)
+	}
+	return d
+}
+
+// MarkFailed 在调用 cli 出现传输层错误时丢弃这个连接，下次使用时重连。
+// cli 已经被替换时忽略，避免并发的失败调用关闭新连接。
+func (m *Manager) MarkFailed(cli *client.Client, reason string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cli != cli || m.state != StateReady {
+		return
+	}
+	clientDisconnects.WithLabelValues(reason).Inc()
+	log.Printf("mcp: dropping connection %d (%s): %v", m.generation, reason, err)
+	m.cli.Close()
+	m.cli = nil
+	m.failures = 1
+	m.lastErr = err
+	m.nextAttempt = time.Now()
+	m.setState(StateBackoff)
+}
+
+// isTransportError 判断 CallTool 等调用的错误是否来自传输层，JSON-RPC 错误和工具错误不需要重连。
+func isTransportError(err error) bool {
+	var te *TransportError
+	return errors.As(err, &te)
+}
+
+// StartHealthMonitor 每隔 HealthInterval 对已连接的客户端发送 ping，SSE 流断开或子进程退出后 ping 会失败，
+// 此时丢弃连接；连接断开且已过退避时间时主动重连，不必等到下一次调用。ctx 取消后退出并关闭连接。
+func (m *Manager) StartHealthMonitor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				m.Close()
+				return
+			case <-ticker.C:
+				m.checkHealth(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Manager) checkHealth(ctx context.Context) {
+	m.mu.Lock()
+	cli, state, used, due := m.cli, m.state, m.used, !time.Now().Before(m.nextAttempt)
+	m.mu.Unlock()
+
+	switch {
+	case state == StateReady:
+		pctx, cancel := context.WithTimeout(ctx, m.PingTimeout)
+		err := cli.Ping(pctx)
+		cancel()
+		if err != nil {
+			clientPings.WithLabelValues("error").Inc()
+			m.MarkFailed(cli, "health_check", err)
+			return
+		}
+		clientPings.WithLabelValues("success").Inc()
+	case state == StateBackoff && used && due:
+		// 失败已在 connect 中记录
+		_, _ = m.connect(ctx)
+	}
+}
+
+// Close 关闭当前连接，之后的调用会重新连接。
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cli == nil {
+		return nil
+	}
+	err := m.cli.Close()
+	m.cli = nil
+	m.setState(StateIdle)
+	return err
+}
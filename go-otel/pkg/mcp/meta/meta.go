@@ -0,0 +1,45 @@
+// Package meta 在 MCP 请求的 _meta 字段中传递 W3C trace context 和 baggage，
+// 让客户端、MCP 服务器和工具处理逻辑属于同一条 trace，且与传输方式无关。
+package meta
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Inject 把 ctx 中的 traceparent、tracestate 和 baggage 写入 m，m 为 nil 时新建一个。
+// m 中已有的其他字段保持不变。
+func Inject(ctx context.Context, m *mcp.Meta) *mcp.Meta {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return m
+	}
+	if m == nil {
+		m = &mcp.Meta{}
+	}
+	if m.AdditionalFields == nil {
+		m.AdditionalFields = make(map[string]any, len(carrier))
+	}
+	for k, v := range carrier {
+		m.AdditionalFields[k] = v
+	}
+	return m
+}
+
+// Extract 返回带有 m 中 trace context 和 baggage 的 ctx，m 中没有时原样返回 ctx。
+func Extract(ctx context.Context, m *mcp.Meta) context.Context {
+	if m == nil {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	for k, v := range m.AdditionalFields {
+		if s, ok := v.(string); ok {
+			carrier[k] = s
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
@@ -0,0 +1,282 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+// methodToolsListChanged 是服务器工具列表变化时发送的通知。
+const methodToolsListChanged = "notifications/tools/list_changed"
+
+// ErrUnknownTool 表示 MCP 服务器没有提供该工具。
+var ErrUnknownTool = errors.New("mcp: unknown tool")
+
+// SchemaError 表示参数不符合工具的 input schema，调用未发出。
+type SchemaError struct {
+	Tool   string
+	Field  string
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("mcp: invalid arguments for tool %s: %s %s", e.Tool, e.Field, e.Reason)
+}
+
+// ToolError 表示工具执行失败（结果的 isError 为 true），Message 是工具返回的文本。
+type ToolError struct {
+	Tool    string
+	Message string
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("mcp: tool %s failed: %s", e.Tool, e.Message)
+}
+
+func (m *Manager) invalidateTools() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tools = nil
+}
+
+// Tools 返回 MCP 服务器提供的工具，结果按连接缓存。
+func (m *Manager) Tools(ctx context.Context) (map[string]mcp.Tool, error) {
+	cli, err := m.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	if m.tools != nil && m.toolsGen == m.generation && time.Since(m.toolsFetched) < m.ToolsTTL {
+		tools := m.tools
+		m.mu.Unlock()
+		return tools, nil
+	}
+	gen := m.generation
+	m.mu.Unlock()
+
+	result, err := cli.ListTools(ctx, mcp.ListToolsRequest{})
+	if isTransportError(err) {
+		m.MarkFailed(cli, "transport_error", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("MCP 工具列表获取失败: %w", err)
+	}
+	tools := make(map[string]mcp.Tool, len(result.Tools))
+	for _, t := range result.Tools {
+		tools[t.Name] = t
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 获取期间连接已经更换时不缓存，下次重新获取
+	if gen == m.generation {
+		m.tools, m.toolsGen, m.toolsFetched = tools, gen, time.Now()
+	}
+	return tools, nil
+}
+
+// ListTools 返回共享客户端连接的 MCP 服务器提供的所有工具，按名称排序。
+func ListTools(ctx context.Context) ([]mcp.Tool, error) {
+	tools, err := shared.Tools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]mcp.Tool, 0, len(tools))
+	for _, t := range tools {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// CallTool 调用名为 name 的工具：in 按 JSON 编码为参数并依据工具的 input schema 校验，
+// 结果中第一个文本或 JSON 资源按 JSON 解码为 Out（Out 为 string 时非 JSON 文本原样返回）。
+// 获取工具列表与调用共用超时预算和熔断器：服务器不可达时直接降级，其他原因无法获取时跳过校验。
+func CallTool[In, Out any](ctx context.Context, name string, in In) (Out, error) {
+	var out Out
+	span := gotrace.SpanFromContext(ctx)
+
+	args, err := toArguments(in)
+	if err != nil {
+		return out, fmt.Errorf("mcp: encode arguments for tool %s: %w", name, err)
+	}
+
+	var result *mcp.CallToolResult
+	// 熔断器打开时不再为获取工具列表去连接服务器
+	if breakerFor(name).State() != BreakerOpen {
+		tools, failed, err := discoverTools(ctx, name)
+		switch {
+		case failed:
+			// 服务器不可达，失败已计入熔断统计，不再发出注定失败的调用
+			result, err = fallback(ctx, name, args, err)
+			if err != nil {
+				return out, err
+			}
+		case err != nil:
+			span.AddEvent("mcp.schema.skipped", gotrace.WithAttributes(attribute.String("mcp.schema.cause", err.Error())))
+		default:
+			tool, ok := tools[name]
+			if !ok {
+				return out, fmt.Errorf("%w %q", ErrUnknownTool, name)
+			}
+			if err := validateArguments(tool, args); err != nil {
+				return out, err
+			}
+		}
+	}
+
+	if result == nil {
+		if result, err = callTool(ctx, name, args); err != nil {
+			return out, err
+		}
+	}
+	if result.IsError {
+		return out, &ToolError{Tool: name, Message: resultText(result)}
+	}
+	if err := decodeResult(result, &out); err != nil {
+		return out, fmt.Errorf("mcp: decode result of tool %s: %w", name, err)
+	}
+	return out, nil
+}
+
+// discoverTools 获取工具列表，与工具调用共用超时预算和 name 的熔断器：
+// 服务器不可达、传输失败或超时计入熔断统计并返回 failed，其他结果只释放放行名额。
+func discoverTools(ctx context.Context, name string) (tools map[string]mcp.Tool, failed bool, err error) {
+	done, err := breakerFor(name).Allow(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer done(false, false)
+	timeout, err := timeoutFor(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tools, err = shared.Tools(cctx)
+	if err != nil && ctx.Err() == nil &&
+		(isTransportError(err) || errors.Is(err, ErrUnavailable) || cctx.Err() != nil) {
+		done(false, true)
+		if cctx.Err() != nil {
+			err = fmt.Errorf("MCP 工具列表获取超时（%s）: %w", timeout, context.DeadlineExceeded)
+		}
+		return nil, true, err
+	}
+	return tools, false, err
+}
+
+// toArguments 把 in 转换为 JSON 对象形式的参数。
+func toArguments(in any) (map[string]any, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	args := map[string]any{}
+	if string(data) == "null" {
+		return args, nil
+	}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, errors.New("arguments must encode to a JSON object")
+	}
+	return args, nil
+}
+
+// validateArguments 按 input schema 检查必填字段、字段类型和枚举值，只支持工具常用的这部分 JSON Schema。
+func validateArguments(tool mcp.Tool, args map[string]any) error {
+	schema := tool.InputSchema
+	for _, field := range schema.Required {
+		if v, ok := args[field]; !ok || v == nil {
+			return &SchemaError{Tool: tool.Name, Field: field, Reason: "is required"}
+		}
+	}
+	for field, v := range args {
+		prop, ok := schema.Properties[field].(map[string]any)
+		if !ok {
+			continue
+		}
+		if typ, ok := prop["type"].(string); ok && !matchesType(typ, v) {
+			return &SchemaError{Tool: tool.Name, Field: field, Reason: "must be of type " + typ}
+		}
+		if enum, ok := prop["enum"].([]any); ok && !slices.Contains(enum, v) {
+			return &SchemaError{Tool: tool.Name, Field: field, Reason: fmt.Sprintf("must be one of %v", enum)}
+		}
+	}
+	return nil
+}
+
+func matchesType(typ string, v any) bool {
+	switch typ {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+// contentText 返回文本内容或文本资源的内容。
+func contentText(c mcp.Content) (string, bool) {
+	if t, ok := mcp.AsTextContent(c); ok {
+		return t.Text, true
+	}
+	if r, ok := mcp.AsEmbeddedResource(c); ok {
+		if t, ok := mcp.AsTextResourceContents(r.Resource); ok {
+			return t.Text, true
+		}
+	}
+	return "", false
+}
+
+func resultText(result *mcp.CallToolResult) string {
+	for _, c := range result.Content {
+		if text, ok := contentText(c); ok {
+			return text
+		}
+	}
+	return ""
+}
+
+func decodeResult(result *mcp.CallToolResult, out any) error {
+	for _, c := range result.Content {
+		text, ok := contentText(c)
+		if !ok {
+			continue
+		}
+		err := json.Unmarshal([]byte(text), out)
+		if err == nil {
+			return nil
+		}
+		if s, ok := out.(*string); ok {
+			*s = text
+			return nil
+		}
+		return err
+	}
+	return errors.New("result has no text content")
+}
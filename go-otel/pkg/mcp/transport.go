@@ -0,0 +1,156 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MCP 客户端支持的传输方式。
+const (
+	TransportSSE            = "sse"
+	TransportStreamableHTTP = "streamable-http"
+	TransportStdio          = "stdio"
+)
+
+// TransportConfig 描述如何连接 MCP 服务器。
+type TransportConfig struct {
+	// Transport 是 TransportSSE、TransportStreamableHTTP 或 TransportStdio
+	Transport string
+	// URL 是 SSE 或 Streamable HTTP 传输的完整服务器地址
+	URL string
+	// Command 和 Args 是 stdio 传输启动的子进程，Env 追加到子进程继承的环境变量之后
+	Command string
+	Args    []string
+	Env     []string
+}
+
+// TransportConfigFromEnv 读取 MCP_TRANSPORT（sse、streamable-http 或 stdio，默认 sse）。
+// SSE 和 Streamable HTTP 的地址由 MCP_SERVER_URL 指定，未设置时为 localhost 上 MCP_SSE_PORT（默认 8184）端口的
+// /sse 或 /mcp；stdio 启动 MCP_SERVER_COMMAND（默认 mcp-server），参数为以空格分隔的 MCP_SERVER_ARGS，
+// 子进程的 MCP_TRANSPORT 固定为 stdio。
+func TransportConfigFromEnv() TransportConfig {
+	conf := TransportConfig{Transport: strings.ToLower(os.Getenv("MCP_TRANSPORT"))}
+	switch conf.Transport {
+	case "", TransportSSE:
+		conf.Transport = TransportSSE
+		conf.URL = serverURL("/sse")
+	case "http", TransportStreamableHTTP:
+		conf.Transport = TransportStreamableHTTP
+		conf.URL = serverURL("/mcp")
+	case TransportStdio:
+		conf.Command = os.Getenv("MCP_SERVER_COMMAND")
+		if conf.Command == "" {
+			conf.Command = "mcp-server"
+		}
+		conf.Args = strings.Fields(os.Getenv("MCP_SERVER_ARGS"))
+		conf.Env = []string{"MCP_TRANSPORT=" + TransportStdio}
+	}
+	return conf
+}
+
+// serverURL 返回 MCP_SERVER_URL，未设置时返回 localhost 上默认端口的 path。
+func serverURL(path string) string {
+	if url := os.Getenv("MCP_SERVER_URL"); url != "" {
+		return url
+	}
+	port := os.Getenv("MCP_SSE_PORT")
+	if port == "" {
+		port = "8184"
+	}
+	return fmt.Sprintf("http://localhost:%s%s", strings.TrimPrefix(port, ":"), path)
+}
+
+// Target 返回用于日志和 span 属性的连接目标：服务器地址或子进程命令行。
+func (c TransportConfig) Target() string {
+	if c.Transport == TransportStdio {
+		return strings.Join(append([]string{c.Command}, c.Args...), " ")
+	}
+	return c.URL
+}
+
+// newClient 按传输方式创建尚未 Start 的客户端。
+func (c TransportConfig) newClient() (*client.Client, error) {
+	var (
+		t   transport.Interface
+		err error
+	)
+	switch c.Transport {
+	case TransportSSE:
+		t, err = transport.NewSSE(c.URL)
+	case TransportStreamableHTTP:
+		t, err = transport.NewStreamableHTTP(c.URL)
+	case TransportStdio:
+		t = transport.NewStdio(c.Command, c.Env, c.Args...)
+	default:
+		return nil, fmt.Errorf("unknown MCP_TRANSPORT %q", c.Transport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s transport: %w", c.Transport, err)
+	}
+	return client.NewClient(&trackedTransport{Interface: t}), nil
+}
+
+// TransportError 是传输层（HTTP 请求、SSE 流、子进程管道）返回的错误，出现时连接需要重建；
+// 服务器返回的 JSON-RPC 错误和工具错误不会包装成 TransportError。
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string { return e.Err.Error() }
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// trackedTransport 把底层传输启动、发送请求和通知时的错误包装成 *TransportError。
+// mcp-go 只用 fmt.Errorf 包装这些错误，没有可供判断的类型。
+type trackedTransport struct {
+	transport.Interface
+}
+
+func (t *trackedTransport) Start(ctx context.Context) error {
+	if err := t.Interface.Start(ctx); err != nil {
+		return &TransportError{Err: err}
+	}
+	return nil
+}
+
+func (t *trackedTransport) SendRequest(ctx context.Context, req transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	resp, err := t.Interface.SendRequest(ctx, req)
+	if err != nil {
+		return nil, &TransportError{Err: err}
+	}
+	return resp, nil
+}
+
+func (t *trackedTransport) SendNotification(ctx context.Context, n mcp.JSONRPCNotification) error {
+	if err := t.Interface.SendNotification(ctx, n); err != nil {
+		return &TransportError{Err: err}
+	}
+	return nil
+}
+
+// forwardStderr 把 stdio 子进程的 stderr 逐行写入日志。子进程的日志都写到 stderr，
+// 不读取的话管道写满后子进程会阻塞。
+func forwardStderr(cli *client.Client) {
+	tt, ok := cli.GetTransport().(*trackedTransport)
+	if !ok {
+		return
+	}
+	stdio, ok := tt.Interface.(*transport.Stdio)
+	if !ok {
+		return
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdio.Stderr())
+		for scanner.Scan() {
+			log.Printf("mcp-server: %s", scanner.Text())
+		}
+	}()
+}
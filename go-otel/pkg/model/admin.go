@@ -0,0 +1,29 @@
+package model
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth 保护 /admin 下的管理接口：配置了 ADMIN_TOKEN 时要求
+// "Authorization: Bearer <token>"，未配置时不做校验，方便本地演示。
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			c.Next()
+			return
+		}
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(c, newProblem(http.StatusUnauthorized, "unauthorized", "a valid admin bearer token is required"), nil)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,191 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	auditActionCreate = "create"
+	auditActionUpdate = "update"
+	auditActionDelete = "delete"
+
+	// actorHeader 标识发起变更的调用方，未设置时记为 anonymous
+	actorHeader = "X-Actor"
+)
+
+const createAuditTable = `
+CREATE TABLE IF NOT EXISTS user_audit (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	actor VARCHAR(128) NOT NULL,
+	before_data TEXT,
+	after_data TEXT,
+	diff TEXT,
+	trace_id VARCHAR(32),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	KEY idx_user_audit_user_id (user_id)
+);`
+
+const createOutboxTable = `
+CREATE TABLE IF NOT EXISTS user_outbox (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	aggregate_id BIGINT NOT NULL,
+	event_type VARCHAR(32) NOT NULL,
+	payload TEXT NOT NULL,
+	trace_carrier TEXT,
+	attempts INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	published_at TIMESTAMP NULL,
+	dead_lettered_at TIMESTAMP NULL,
+	next_attempt_at TIMESTAMP NULL,
+	KEY idx_user_outbox_unpublished (published_at, id),
+	KEY idx_user_outbox_aggregate (aggregate_id, id)
+);`
+
+// initAudit 创建审计表和 outbox 表。
+func initAudit() {
+	for _, stmt := range []string{createAuditTable, createOutboxTable} {
+		if _, err := db.Exec(stmt); err != nil {
+			panic(err)
+		}
+	}
+	// 旧表缺少的列和索引在这里补上，已存在时忽略
+	for _, stmt := range []string{
+		"ALTER TABLE user_outbox ADD COLUMN dead_lettered_at TIMESTAMP NULL",
+		"ALTER TABLE user_outbox ADD COLUMN next_attempt_at TIMESTAMP NULL",
+		"ALTER TABLE user_outbox ADD KEY idx_user_outbox_aggregate (aggregate_id, id)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			var myErr *mysql.MySQLError
+			if !errors.As(err, &myErr) || (myErr.Number != mysqlErrDupFieldName && myErr.Number != mysqlErrDupKeyName) {
+				panic(err)
+			}
+		}
+	}
+}
+
+// fieldChange 记录一个字段变更前后的值。
+type fieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// UserChangeEvent 是写入 outbox 并发布到 Redis Stream 的用户变更事件。
+type UserChangeEvent struct {
+	Action     string                 `json:"action"`
+	UserId     int64                  `json:"user_id"`
+	Actor      string                 `json:"actor"`
+	Before     *User                  `json:"before,omitempty"`
+	After      *User                  `json:"after,omitempty"`
+	Diff       map[string]fieldChange `json:"diff"`
+	TraceId    string                 `json:"trace_id,omitempty"`
+	OccurredAt time.Time              `json:"occurred_at"`
+}
+
+// actorFromRequest 返回请求的操作者。
+func actorFromRequest(c *gin.Context) string {
+	if actor := c.GetHeader(actorHeader); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// diffUsers 比较两个用户的业务字段，before 或 after 为 nil 时表示创建或删除。
+func diffUsers(before, after *User) map[string]fieldChange {
+	var b, a User
+	if before != nil {
+		b = *before
+	}
+	if after != nil {
+		a = *after
+	}
+	diff := map[string]fieldChange{}
+	add := func(name string, from, to any, changed bool) {
+		if changed {
+			diff[name] = fieldChange{From: from, To: to}
+		}
+	}
+	add("name", b.Name, a.Name, b.Name != a.Name)
+	add("gender", b.Gender, a.Gender, b.Gender != a.Gender)
+	add("phone", b.Phone, a.Phone, b.Phone != a.Phone)
+	add("email", b.Email, a.Email, b.Email != a.Email)
+	add("age", b.Age, a.Age, b.Age != a.Age)
+	return diff
+}
+
+// nullJSON 把 v 序列化为 JSON，v 为 nil 指针时返回 NULL。
+func nullJSON(u *User) (sql.NullString, error) {
+	if u == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// recordUserChange 在 tx 中写入审计记录和 outbox 事件，必须与业务变更在同一事务中调用。
+// 当前的 W3C trace context 随事件一起保存，outbox relay 发布时会据此延续链路。
+func recordUserChange(ctx context.Context, tx *sql.Tx, action, actor string, before, after *User) error {
+	event := UserChangeEvent{
+		Action:     action,
+		Actor:      actor,
+		Before:     before,
+		After:      after,
+		Diff:       diffUsers(before, after),
+		OccurredAt: time.Now().UTC(),
+	}
+	if after != nil {
+		event.UserId = after.Id
+	} else if before != nil {
+		event.UserId = before.Id
+	}
+	if sc := gotrace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		event.TraceId = sc.TraceID().String()
+	}
+
+	beforeData, err := nullJSON(before)
+	if err != nil {
+		return err
+	}
+	afterData, err := nullJSON(after)
+	if err != nil {
+		return err
+	}
+	diff, err := json.Marshal(event.Diff)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO user_audit (user_id, action, actor, before_data, after_data, diff, trace_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		event.UserId, action, actor, beforeData, afterData, string(diff), event.TraceId); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	carrierData, err := json.Marshal(carrier)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO user_outbox (aggregate_id, event_type, payload, trace_carrier) VALUES (?, ?, ?, ?)",
+		event.UserId, "user."+action, string(payload), string(carrierData))
+	return err
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.28.0"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
+)
+
+// mysqlDriverName 是带故障注入的 MySQL 驱动，otelsql 在其外层记录 span。
+const mysqlDriverName = "mysql+fault"
+
+func init() {
+	sql.Register(mysqlDriverName, fault.WrapDriver(&mysql.MySQLDriver{}, fault.TargetMySQL))
+}
+
+// 连接池默认值，均可通过环境变量覆盖
+const (
+	defaultMaxOpenConns    = 20
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultQueryTimeout    = 5 * time.Second
+	// 从请求的 deadline 中预留给写响应等收尾工作的时间
+	queryDeadlineMargin = 50 * time.Millisecond
+)
+
+var (
+	// replicas 是只读副本连接池，为空时读请求也走主库
+	replicas    []*sql.DB
+	replicaNext atomic.Uint64
+)
+
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// openDB 打开一个带 OTel tracing 的 MySQL 连接池，按环境变量配置池参数，
+// 并把连接池统计注册为 OTel 指标。role 为 primary 或 replica。
+func openDB(address, serverAddress, role string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		address,
+		os.Getenv("DB_NAME"))
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemMySQL,
+		semconv.ServerAddress(serverAddress),
+		semconv.DBNamespace(os.Getenv("DB_NAME")),
+		attribute.String("db.role", role),
+	}
+	conn, err := otelsql.Open(mysqlDriverName, dsn,
+		otelsql.WithAttributes(attrs...),
+		otelsql.WithDisableSkipErrMeasurement(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(envInt("DB_MAX_OPEN_CONNS", defaultMaxOpenConns))
+	conn.SetMaxIdleConns(envInt("DB_MAX_IDLE_CONNS", defaultMaxIdleConns))
+	conn.SetConnMaxLifetime(envDuration("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime))
+	conn.SetConnMaxIdleTime(envDuration("DB_CONN_MAX_IDLE_TIME", defaultConnMaxIdleTime))
+
+	// 连接池统计（打开/空闲/等待等）通过 MeterProvider 导出
+	if err := otelsql.RegisterDBStatsMetrics(conn, otelsql.WithAttributes(attrs...)); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// initReplicas 按 DB_REPLICA_ADDRESSES（逗号分隔的 host:port）打开只读副本，
+// 账号和库名与主库相同。
+func initReplicas() {
+	for _, addr := range strings.Split(os.Getenv("DB_REPLICA_ADDRESSES"), ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		replica, err := openDB(addr, addr, "replica")
+		if err != nil {
+			panic(err)
+		}
+		replicas = append(replicas, replica)
+		log.Printf("mysql read replica: %s", addr)
+	}
+}
+
+// readDB 返回用于只读查询的连接池：配置了副本时轮询选择一个，否则返回主库。
+func readDB() *sql.DB {
+	if len(replicas) == 0 {
+		return db
+	}
+	return replicas[replicaNext.Add(1)%uint64(len(replicas))]
+}
+
+// primaryServerAddress 返回主库用于 server.address 属性的地址。
+func primaryServerAddress() string {
+	if port := os.Getenv("DB_PORT"); port != "" {
+		return net.JoinHostPort(os.Getenv("DB_ADDRESS"), port)
+	}
+	return os.Getenv("DB_ADDRESS")
+}
+
+// withQueryTimeout 为一次数据库操作派生超时：不超过 DB_QUERY_TIMEOUT，
+// 请求本身带有 deadline 时再留出 queryDeadlineMargin 给写响应。
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := envDuration("DB_QUERY_TIMEOUT", defaultQueryTimeout)
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline) - queryDeadlineMargin; remaining < timeout {
+			timeout = max(remaining, 0)
+		}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
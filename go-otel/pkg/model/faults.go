@@ -0,0 +1,91 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
+)
+
+// faultExemptPrefixes 是不注入 HTTP 故障的路径：管理接口、指标、readiness 和 pprof，
+// 避免一条 "http" 规则把故障演练自身的控制面也打挂。
+var faultExemptPrefixes = []string{"/admin/", "/metrics", "/readyz", "/debug/"}
+
+// FaultInjection 按 "http.<METHOD> <路由>" 对业务接口注入故障，如 "http.GET /roll"。
+// 注入的错误返回 503，超时返回 504；panic 交给 gin 的 Recovery 处理。
+// 请求的 context 会标记为 fault.WithRecover，之后 Redis、MySQL 和 MCP 上的 panic 规则才会真正 panic，
+// 必须注册在 Recovery 之后。
+func FaultInjection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(fault.WithRecover(c.Request.Context()))
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+		for _, p := range faultExemptPrefixes {
+			if strings.HasPrefix(route, p) {
+				c.Next()
+				return
+			}
+		}
+
+		if err := fault.Inject(c.Request.Context(), fault.TargetHTTP+"."+c.Request.Method+" "+route); err != nil {
+			status := http.StatusServiceUnavailable
+			if errors.Is(err, context.DeadlineExceeded) {
+				status = http.StatusGatewayTimeout
+			}
+			writeProblem(c, newProblem(status, "fault-injected", err.Error()), err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// ListFaults 返回当前生效的故障注入规则。
+func ListFaults(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"items": fault.List()})
+}
+
+// CreateFault 添加一条故障注入规则。
+func CreateFault(c *gin.Context) {
+	var rule fault.Rule
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", err.Error()), err)
+		return
+	}
+
+	created, err := fault.Add(rule)
+	if err != nil {
+		p := newProblem(http.StatusBadRequest, "validation-error", "one or more fields are invalid")
+		var ruleErr *fault.RuleError
+		if errors.As(err, &ruleErr) {
+			p.InvalidParams = []FieldError{{Name: ruleErr.Field, Reason: ruleErr.Reason}}
+		}
+		writeProblem(c, p, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// DeleteFault 删除一条故障注入规则。
+func DeleteFault(c *gin.Context) {
+	if !fault.Remove(c.Param("id")) {
+		writeProblem(c, newProblem(http.StatusNotFound, "fault-not-found", "fault rule not found"), nil)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ClearFaults 删除全部故障注入规则，用于结束一次演练。
+func ClearFaults(c *gin.Context) {
+	fault.Clear()
+	c.Status(http.StatusNoContent)
+}
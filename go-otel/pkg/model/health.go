@@ -0,0 +1,23 @@
+package model
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+// Readiness 处理 GET /readyz：依据 Redis 健康探测的结果返回 200 或 503，
+// 供负载均衡和 Kubernetes readinessProbe 使用。
+func Readiness(c *gin.Context) {
+	h := redis.CurrentHealth()
+	status, code := "ready", http.StatusOK
+	if !h.Healthy {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": gin.H{"redis": h},
+	})
+}
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyKeyPrefix = "go-demo:idempotency:"
+	// 同一个 key 的首个请求尚未处理完时，占位记录的存活时间
+	idempotencyLockTTL = 30 * time.Second
+	// 为计算请求指纹读取的请求体上限
+	idempotencyMaxBody = 1 << 20
+	// 请求处理完后保存或删除幂等记录的超时
+	idempotencySaveTimeout = 2 * time.Second
+)
+
+// idempotencyRecord 是保存在 Redis 中的幂等记录。
+// Status 为 0 表示首个请求仍在处理中。
+type idempotencyRecord struct {
+	RequestHash string          `json:"request_hash"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// idempotencyTTL 返回幂等记录的保存时长，可通过 IDEMPOTENCY_TTL 配置（如 "24h"）。
+func idempotencyTTL() time.Duration {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 24 * time.Hour
+}
+
+// idempotencyRedisKey 返回幂等记录的 Redis key。key 按方法、路由和调用方隔离，
+// 不同接口或不同客户端使用相同的 Idempotency-Key 不会互相冲突。
+func idempotencyRedisKey(c *gin.Context, key string) string {
+	sum := sha256.Sum256([]byte(c.Request.Method + " " + c.FullPath() + "\n" + clientIdentity(c) + "\n" + key))
+	return idempotencyKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// bodyRecorder 在写出响应的同时保留一份副本，用于保存幂等记录。
+type bodyRecorder struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency 为写接口提供基于 Idempotency-Key 请求头的幂等保护：
+// 相同 key 和相同请求体的重放直接返回首次的响应，
+// 相同 key 但请求体不同的请求返回 422。
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		span := gotrace.SpanFromContext(ctx)
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, idempotencyMaxBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeProblem(c, newProblem(http.StatusRequestEntityTooLarge, "body-too-large",
+				fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)), nil)
+			return
+		}
+		if err != nil {
+			writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", "failed to read request body"), err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+		redisKey := idempotencyRedisKey(c, key)
+
+		span.SetAttributes(attribute.String("idempotency.key", key))
+
+		pending, _ := json.Marshal(idempotencyRecord{RequestHash: hash})
+		ok, err := redis.Rdb.SetNX(ctx, redisKey, pending, idempotencyLockTTL).Result()
+		if err != nil {
+			// Redis 不可用时不阻塞业务，退化为普通请求
+			span.RecordError(err)
+			c.Next()
+			return
+		}
+		if !ok {
+			replayIdempotent(c, redisKey, hash)
+			return
+		}
+
+		rec := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+
+		// 客户端此时可能已经断开，但写入已经生效，记录必须保存下来，否则重试会再执行一次
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencySaveTimeout)
+		defer cancel()
+		status := rec.Status()
+		if status >= http.StatusInternalServerError {
+			// 服务端错误允许客户端使用同一个 key 重试
+			if err := redis.Rdb.Del(saveCtx, redisKey).Err(); err != nil {
+				span.RecordError(err)
+			}
+			return
+		}
+		done, _ := json.Marshal(idempotencyRecord{
+			RequestHash: hash,
+			Status:      status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.buf.Bytes(),
+		})
+		if err := redis.Rdb.Set(saveCtx, redisKey, done, idempotencyTTL()).Err(); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+// replayIdempotent 处理已经出现过的 Idempotency-Key。
+func replayIdempotent(c *gin.Context, redisKey, hash string) {
+	ctx := c.Request.Context()
+	span := gotrace.SpanFromContext(ctx)
+
+	raw, err := redis.Rdb.Get(ctx, redisKey).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		writeProblem(c, newProblem(http.StatusConflict, "idempotency-expired", "idempotency key expired during request, retry"), nil)
+		return
+	}
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "idempotency-store-error", "idempotency store error"), err)
+		return
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "idempotency-store-error", "idempotency store error"), err)
+		return
+	}
+	if record.RequestHash != hash {
+		writeProblem(c, newProblem(http.StatusUnprocessableEntity, "idempotency-key-reused", "idempotency key reused with a different request body"), nil)
+		return
+	}
+	if record.Status == 0 {
+		writeProblem(c, newProblem(http.StatusConflict, "idempotency-in-progress", "request with this idempotency key is in progress"), nil)
+		return
+	}
+
+	span.SetAttributes(attribute.Bool("idempotency.replayed", true))
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.Status, record.ContentType, record.Body)
+	c.Abort()
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+const jobUserWelcome = "user.welcome"
+
+// userJobs 是处理用户相关后台任务的队列。
+var userJobs = redis.NewQueue("user")
+
+type welcomeJob struct {
+	UserId int64  `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// enqueueWelcome 为新用户投递欢迎任务，失败只记录日志，不影响用户创建。
+func enqueueWelcome(ctx context.Context, u User) {
+	if _, err := userJobs.Enqueue(ctx, jobUserWelcome, welcomeJob{UserId: u.Id, Name: u.Name}); err != nil {
+		gotrace.SpanFromContext(ctx).RecordError(err)
+		log.Printf("enqueue %s for user %d failed: %v", jobUserWelcome, u.Id, err)
+	}
+}
+
+// StartJobWorkers 在后台消费 userJobs，并发数可通过 JOB_WORKER_CONCURRENCY 配置，ctx 取消后退出。
+func StartJobWorkers(ctx context.Context) {
+	go func() {
+		if err := userJobs.Work(ctx, "", envInt("JOB_WORKER_CONCURRENCY", 4), handleUserJob); err != nil && ctx.Err() == nil {
+			log.Printf("job worker stopped: %v", err)
+		}
+	}()
+}
+
+func handleUserJob(ctx context.Context, job *redis.Job) error {
+	switch job.Type {
+	case jobUserWelcome:
+		var j welcomeJob
+		if err := job.Decode(&j); err != nil {
+			return err
+		}
+		gotrace.SpanFromContext(ctx).SetAttributes(attribute.Int64("user.id", j.UserId))
+		// SET NX 保证重试或重复投递时只欢迎一次
+		ok, err := redis.Rdb.SetNX(ctx, fmt.Sprintf("go-demo:welcomed:%d", j.UserId), job.Id, 24*time.Hour).Result()
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Printf("welcome, %s (user %d)", j.Name, j.UserId)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown job type %q", job.Type)
+	}
+}
@@ -0,0 +1,227 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+const (
+	outboxBatchSize = 100
+	// outboxMaxLen 是事件流保留的近似最大长度
+	outboxMaxLen = 100000
+)
+
+// outboxStream 返回用户变更事件发布到的 Redis Stream，可通过 USER_EVENTS_STREAM 配置。
+func outboxStream() string {
+	if v := os.Getenv("USER_EVENTS_STREAM"); v != "" {
+		return v
+	}
+	return "go-demo:user-events"
+}
+
+// outboxMaxAttempts 返回一条事件最多发布几次，超过后转入死信，可通过 OUTBOX_MAX_ATTEMPTS 配置。
+func outboxMaxAttempts() int {
+	return envInt("OUTBOX_MAX_ATTEMPTS", 10)
+}
+
+// outboxBackoff 返回第 attempts 次发布失败后到下次重试的等待时间，从 OUTBOX_MIN_BACKOFF（默认 1s）开始翻倍，
+// 不超过 OUTBOX_MAX_BACKOFF（默认 5m）。默认配置下 Redis 中断约 8 分钟后事件才会转入死信。
+func outboxBackoff(attempts int) time.Duration {
+	minBackoff := envDuration("OUTBOX_MIN_BACKOFF", time.Second)
+	maxBackoff := envDuration("OUTBOX_MAX_BACKOFF", 5*time.Minute)
+	if attempts < 1 || attempts > 32 {
+		return maxBackoff
+	}
+	return min(minBackoff<<(attempts-1), maxBackoff)
+}
+
+// outboxPollInterval 返回 relay 的轮询间隔，可通过 OUTBOX_POLL_INTERVAL 配置（如 "1s"）。
+func outboxPollInterval() time.Duration {
+	if v := os.Getenv("OUTBOX_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return time.Second
+}
+
+// StartOutboxRelay 启动后台 goroutine，把 user_outbox 中未发布的事件投递到 Redis Stream，
+// ctx 取消后退出。
+func StartOutboxRelay(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// 一整批都发布成功时说明可能还有积压，立即继续
+				for {
+					n, err := relayOutboxOnce(ctx)
+					if err != nil {
+						log.Printf("outbox relay failed: %v", err)
+						break
+					}
+					if n < outboxBatchSize {
+						break
+					}
+				}
+			}
+		}
+	}()
+}
+
+type outboxEntry struct {
+	id          int64
+	aggregateId int64
+	eventType   string
+	payload     string
+	carrier     sql.NullString
+	attempts    int
+}
+
+// relayOutboxOnce 发布一批事件，返回本批发布成功的条数。
+// 使用 FOR UPDATE SKIP LOCKED，多个副本同时运行时不会重复投递同一条事件。
+// 同一个用户的事件按 id 顺序发布：某条发布失败后，本批中该用户之后的事件都留到下一轮；
+// 失败的事件按 outboxBackoff 推迟重试，等待期间该用户之后的事件也不会被选中。
+// 失败次数达到 outboxMaxAttempts 的事件转入死信，不再阻塞该用户后续的事件。
+func relayOutboxOnce(ctx context.Context) (int, error) {
+	ctx, span := otel.Tracer("user").Start(ctx, "outbox.relay")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 只选到期的事件，并跳过前面还有未到期事件的用户，保证同一用户的事件不乱序
+	rows, err := tx.QueryContext(ctx, `SELECT id, aggregate_id, event_type, payload, trace_carrier, attempts FROM user_outbox o
+		WHERE published_at IS NULL AND dead_lettered_at IS NULL
+			AND (next_attempt_at IS NULL OR next_attempt_at <= CURRENT_TIMESTAMP)
+			AND NOT EXISTS (SELECT 1 FROM user_outbox p WHERE p.aggregate_id = o.aggregate_id AND p.id < o.id
+				AND p.published_at IS NULL AND p.dead_lettered_at IS NULL AND p.next_attempt_at > CURRENT_TIMESTAMP)
+		ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`,
+		outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var entries []outboxEntry
+	for rows.Next() {
+		var e outboxEntry
+		if err := rows.Scan(&e.id, &e.aggregateId, &e.eventType, &e.payload, &e.carrier, &e.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	span.SetAttributes(attribute.Int("outbox.batch_size", len(entries)))
+	published := 0
+	maxAttempts := outboxMaxAttempts()
+	// blocked 是本批中已有事件发布失败的用户
+	blocked := map[int64]bool{}
+	for _, e := range entries {
+		if blocked[e.aggregateId] {
+			continue
+		}
+		if err := publishOutboxEntry(ctx, e); err != nil {
+			if e.attempts+1 >= maxAttempts {
+				if _, err := tx.ExecContext(ctx, "UPDATE user_outbox SET dead_lettered_at = CURRENT_TIMESTAMP, attempts = attempts + 1 WHERE id = ?", e.id); err != nil {
+					return 0, err
+				}
+				span.AddEvent("outbox.dead_lettered", gotrace.WithAttributes(
+					attribute.Int64("outbox.id", e.id),
+					attribute.Int64("user.id", e.aggregateId),
+				))
+				log.Printf("outbox event %d (user %d) dead-lettered after %d attempts: %v", e.id, e.aggregateId, e.attempts+1, err)
+				continue
+			}
+			// 记录失败次数，退避后重试
+			backoff := outboxBackoff(e.attempts + 1)
+			if _, err := tx.ExecContext(ctx, "UPDATE user_outbox SET attempts = attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + INTERVAL ? SECOND WHERE id = ?",
+				int(backoff.Seconds()), e.id); err != nil {
+				return 0, err
+			}
+			blocked[e.aggregateId] = true
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE user_outbox SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1 WHERE id = ?", e.id); err != nil {
+			return 0, err
+		}
+		published++
+	}
+	return published, tx.Commit()
+}
+
+// publishOutboxEntry 在产生事件的那条链路下创建 producer span，
+// 并把该 span 的 trace context 写进消息字段，消费者可以据此继续同一条 trace。
+func publishOutboxEntry(ctx context.Context, e outboxEntry) error {
+	carrier := propagation.MapCarrier{}
+	if e.carrier.Valid {
+		_ = json.Unmarshal([]byte(e.carrier.String), &carrier)
+	}
+	// 提取出的远端 span 会替代 outbox.relay 成为 parent，relay span 以 link 的形式保留
+	parent := otel.GetTextMapPropagator().Extract(ctx, carrier)
+
+	stream := outboxStream()
+	pctx, span := otel.Tracer("user").Start(parent, "outbox.publish "+stream,
+		gotrace.WithSpanKind(gotrace.SpanKindProducer),
+		gotrace.WithLinks(gotrace.LinkFromContext(ctx)),
+		gotrace.WithAttributes(
+			attribute.String("messaging.system", "redis"),
+			attribute.String("messaging.destination.name", stream),
+			attribute.String("messaging.operation.type", "send"),
+			attribute.Int64("outbox.id", e.id),
+			attribute.String("outbox.event_type", e.eventType),
+			attribute.Int64("user.id", e.aggregateId),
+		))
+	defer span.End()
+
+	msgCarrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(pctx, msgCarrier)
+	values := map[string]any{
+		"event_type":   e.eventType,
+		"aggregate_id": e.aggregateId,
+		"outbox_id":    e.id,
+		"payload":      e.payload,
+	}
+	for k, v := range msgCarrier {
+		values[k] = v
+	}
+
+	id, err := redis.Rdb.XAdd(pctx, &goredis.XAddArgs{
+		Stream: stream,
+		MaxLen: outboxMaxLen,
+		Approx: true,
+		Values: values,
+	}).Result()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		return err
+	}
+	span.SetAttributes(attribute.String("messaging.message.id", id))
+	return nil
+}
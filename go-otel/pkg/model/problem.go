@@ -0,0 +1,65 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypeBase 是各类错误 type URI 的前缀。
+const problemTypeBase = "https://github.com/flashcatcloud/Demo/go-otel/problems/"
+
+// Problem 是 RFC 7807 定义的错误响应体。
+type Problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	InvalidParams []FieldError `json:"invalid-params,omitempty"`
+}
+
+// newProblem 根据状态码构造一个 Problem，type 取 problemTypeBase + kind。
+func newProblem(status int, kind, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypeBase + kind,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// writeProblem 以 application/problem+json 返回错误，并把错误记录到当前 span 上。
+// cause 为导致该错误的底层错误，可以为 nil。
+func writeProblem(c *gin.Context, p *Problem, cause error) {
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+
+	span := gotrace.SpanFromContext(c.Request.Context())
+	if cause == nil {
+		cause = errors.New(p.Detail)
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("problem.type", p.Type),
+		attribute.Int("problem.status", p.Status),
+	}
+	for _, fe := range p.InvalidParams {
+		attrs = append(attrs, attribute.String("problem.invalid_param."+fe.Name, fe.Reason))
+	}
+	span.RecordError(cause, gotrace.WithAttributes(attrs...))
+	if p.Status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, p.Detail)
+	}
+
+	body, _ := json.Marshal(p)
+	c.Data(p.Status, problemContentType, body)
+	c.Abort()
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+const (
+	rateLimitKeyPrefix = "go-demo:ratelimit:"
+	apiKeyHeader       = "X-API-Key"
+)
+
+var rateLimitChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_rate_limit_checks_total",
+	Help: "The total number of rate limit checks by policy, route and result (allowed, limited or error)",
+}, []string{"policy", "route", "result"})
+
+func init() {
+	prometheus.MustRegister(rateLimitChecks)
+}
+
+// RateLimitKeyFunc 返回限流的维度，相同返回值的请求共享同一份额度。
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// RateLimitByClient 按 X-API-Key 限流，没有 API key 时按客户端 IP。
+func RateLimitByClient(c *gin.Context) string {
+	return clientIdentity(c)
+}
+
+// clientIdentity 返回调用方的标识：X-API-Key 的哈希，没有 API key 时为客户端 IP。
+// API key 只以哈希的形式出现在 Redis key 中。
+func clientIdentity(c *gin.Context) string {
+	if key := c.GetHeader(apiKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	return RateLimitByIP(c)
+}
+
+// RateLimitByIP 按客户端 IP 限流。
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimitByRoute 按路由限流，所有客户端共享同一份额度。
+func RateLimitByRoute(c *gin.Context) string {
+	return "route:" + c.Request.Method + " " + c.FullPath()
+}
+
+// parseLimit 解析 "<rate>/<period>[,<burst>]" 形式的配额，如 "10/1s,20"、"100/1m"，burst 默认等于 rate。
+func parseLimit(spec string) (redis.Limit, error) {
+	ratePart, rest, ok := strings.Cut(spec, "/")
+	if !ok {
+		return redis.Limit{}, fmt.Errorf("rate limit %q: expected <rate>/<period>[,<burst>]", spec)
+	}
+	periodPart, burstPart, hasBurst := strings.Cut(rest, ",")
+
+	var (
+		l   redis.Limit
+		err error
+	)
+	if l.Rate, err = strconv.Atoi(strings.TrimSpace(ratePart)); err != nil || l.Rate <= 0 {
+		return redis.Limit{}, fmt.Errorf("rate limit %q: rate must be a positive integer", spec)
+	}
+	if l.Period, err = time.ParseDuration(strings.TrimSpace(periodPart)); err != nil || l.Period <= 0 {
+		return redis.Limit{}, fmt.Errorf("rate limit %q: period must be a positive duration", spec)
+	}
+	l.Burst = l.Rate
+	if hasBurst {
+		if l.Burst, err = strconv.Atoi(strings.TrimSpace(burstPart)); err != nil || l.Burst <= 0 {
+			return redis.Limit{}, fmt.Errorf("rate limit %q: burst must be a positive integer", spec)
+		}
+	}
+	return l, nil
+}
+
+// RateLimit 返回按 policy 限流的中间件，配额默认为 defaultSpec，可通过 RATE_LIMIT_<POLICY> 覆盖，
+// 设为 "off" 时关闭。响应带有 RateLimit-Limit、RateLimit-Remaining、RateLimit-Reset 和 RateLimit-Policy 头，
+// 超出配额时返回 429 和 Retry-After。Redis 不可用时放行，限流不应成为新的单点故障。
+func RateLimit(policy, defaultSpec string, key RateLimitKeyFunc) gin.HandlerFunc {
+	spec := defaultSpec
+	if v := os.Getenv("RATE_LIMIT_" + strings.ToUpper(policy)); v != "" {
+		spec = v
+	}
+	if spec == "off" {
+		return func(c *gin.Context) { c.Next() }
+	}
+	limit, err := parseLimit(spec)
+	if err != nil {
+		panic(err)
+	}
+	policyHeader := fmt.Sprintf("%d;w=%d;burst=%d;policy=%q", limit.Rate, int(math.Ceil(limit.Period.Seconds())), limit.Burst, policy)
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		route := c.FullPath()
+		span := gotrace.SpanFromContext(ctx)
+
+		res, err := redis.AllowN(ctx, rateLimitKeyPrefix+policy+":"+key(c), limit, 1)
+		if err != nil {
+			rateLimitChecks.WithLabelValues(policy, route, "error").Inc()
+			span.RecordError(err, gotrace.WithAttributes(attribute.String("ratelimit.policy", policy)))
+			log.Printf("rate limit %s failed, allowing request: %v", policy, err)
+			c.Next()
+			return
+		}
+
+		span.SetAttributes(
+			attribute.String("ratelimit.policy", policy),
+			attribute.Bool("ratelimit.allowed", res.Allowed),
+			attribute.Int("ratelimit.remaining", res.Remaining),
+		)
+		h := c.Writer.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+		h.Set("RateLimit-Policy", policyHeader)
+
+		if !res.Allowed {
+			rateLimitChecks.WithLabelValues(policy, route, "limited").Inc()
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			writeProblem(c, newProblem(http.StatusTooManyRequests, "rate-limited",
+				fmt.Sprintf("rate limit %q exceeded, retry in %s", policy, res.RetryAfter.Round(time.Millisecond))), nil)
+			return
+		}
+		rateLimitChecks.WithLabelValues(policy, route, "allowed").Inc()
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
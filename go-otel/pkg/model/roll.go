@@ -2,10 +2,13 @@ package model
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,8 +17,10 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	gotrace "go.opentelemetry.io/otel/trace"
 
+	"github.com/flashcatcloud/Demo/go-otel/pkg/dice"
 	logx "github.com/flashcatcloud/Demo/go-otel/pkg/log"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/mcp"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/random"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/metric"
@@ -42,26 +47,71 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	mcp.SetCalculatorFallback(calculatorFallback)
 }
 
-func RecordMetrics() {
+// recordMetricsLease 是 RecordMetrics 后台任务的 leader 租约时长。
+const recordMetricsLease = 15 * time.Second
+
+// RecordMetrics 注册 opsProcessed，并在所有副本中选出一个 leader 定时递增它，
+// 避免多副本部署时每个副本都在跑同一个后台任务。ctx 结束时退出选举。
+func RecordMetrics(ctx context.Context) {
 	// 注册opsProcessed
 	if err := prometheus.Register(opsProcessed); err != nil {
 		fmt.Println(err)
 	} else {
 		fmt.Println("taskCounter registered.")
 	}
-	go func() {
+	go redis.RunAsLeader(ctx, "record-metrics", recordMetricsLease, func(ctx context.Context, token int64) {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
 		for {
-			opsProcessed.Inc()
-			time.Sleep(2 * time.Second)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				opsProcessed.Inc()
+			}
 		}
-	}()
+	})
+}
+
+// defaultExpression 与最初的实现等价：掷两个六面骰，并通过 MCP calculator 相加。
+const defaultExpression = "1d6+1d6"
+
+// rollRequest 是 POST /roll2 的请求体。
+type rollRequest struct {
+	Expr string `json:"expr"`
+}
+
+// mcpOperations 把骰子表达式的运算符映射为 MCP calculator 工具的 operation。
+var mcpOperations = map[byte]string{
+	'+': "add",
+	'-': "subtract",
+	'*': "multiply",
 }
 
 func Roll(c *gin.Context) {
+	expr := c.Query("expr")
+	if expr == "" && c.Request.Method == http.MethodPost {
+		var req rollRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", "request body is not valid JSON"), err)
+			return
+		}
+		expr = req.Expr
+	}
+	if expr == "" {
+		expr = defaultExpression
+	}
 
-	number, err := rollOnce(c.Request.Context())
+	result, err := rollOnce(c.Request.Context(), expr)
+	if isInvalidExpression(err) {
+		p := newProblem(http.StatusBadRequest, "invalid-dice-expression", err.Error())
+		p.InvalidParams = []FieldError{{Name: "expr", Reason: err.Error()}}
+		writeProblem(c, p, err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
@@ -70,44 +120,80 @@ func Roll(c *gin.Context) {
 	opsProcessed.Inc()
 	// 摇骰子次数的指标 +1
 
-	c.JSON(http.StatusOK, gin.H{"msg": number})
+	if err := saveRoll(c.Request.Context(), callerFromRequest(c), result); err != nil {
+		// 历史记录写失败不影响本次掷骰的结果
+		gotrace.SpanFromContext(c.Request.Context()).RecordError(err)
+		log.Printf("save roll history failed: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"msg":        result.Total,
+		"expression": result.Expression,
+		"total":      result.Total,
+		"rolls":      result.Rolls,
+		// 以字符串返回，避免 JSON 客户端丢失 int64 精度；原样放进 X-Random-Seed 即可重放
+		"seed": strconv.FormatInt(random.FromContext(c.Request.Context()).Seed(), 10),
+	})
 }
 
-func rollOnce(ctx context.Context) (int, error) {
-	ctx, span := otel.Tracer("roll").Start(ctx, "rollOnce") // 开始 span
-	defer span.End()
-	span.SetAttributes(attribute.String("function", "rollOnce"))
+// isInvalidExpression 判断 err 是否由表达式本身引起（语法错误、骰子过多或结果溢出），这类错误返回 400。
+func isInvalidExpression(err error) bool {
+	var syntaxErr *dice.SyntaxError
+	return errors.As(err, &syntaxErr) || errors.Is(err, dice.ErrTooManyDice) || errors.Is(err, dice.ErrOverflow)
+}
 
-	var (
-		err    error
-		number int
-	)
+// mcpCombine 通过 MCP calculator 工具完成骰子表达式中的运算，
+// MCP 不可用、超时或被熔断时由 calculatorFallback 在本地计算。
+func mcpCombine(ctx context.Context, op byte, x, y int) (int, error) {
+	result, err := mcp.CallCalculatorTool(ctx, mcpOperations[op], float64(x), float64(y))
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(result)), nil
+}
+
+// calculatorFallback 是 calculator 工具的降级逻辑，使用本地计算。
+func calculatorFallback(ctx context.Context, operation string, x, y float64) (float64, error) {
+	for op, name := range mcpOperations {
+		if name == operation {
+			log.Printf("MCP计算失败，使用本地计算: %s(%v, %v)", operation, x, y)
+			v, err := dice.LocalCombine(ctx, op, int(x), int(y))
+			return float64(v), err
+		}
+	}
+	return 0, fmt.Errorf("unknown calculator operation %q", operation)
+}
 
-	// 生成两个随机数（1-6范围，模拟骰子）
-	dice1 := 1 + rand.Intn(6)
-	dice2 := 1 + rand.Intn(6)
+// mcpRollBudget 是一次掷骰中所有 MCP 调用的总时间预算，可通过 MCP_ROLL_BUDGET 配置。
+var mcpRollBudget = envDuration("MCP_ROLL_BUDGET", 2*time.Second)
 
+func rollOnce(ctx context.Context, expr string) (*dice.Result, error) {
+	ctx, span := otel.Tracer("roll").Start(ctx, "rollOnce") // 开始 span
+	defer span.End()
 	span.SetAttributes(
-		attribute.Int("dice1", dice1),
-		attribute.Int("dice2", dice2),
+		attribute.String("function", "rollOnce"),
+		attribute.String("dice.expression", expr),
 	)
 
-	log.Printf("生成随机数: dice1=%d, dice2=%d", dice1, dice2)
-
-	// 调用MCP服务器进行计算
-	result, err := mcp.CallCalculatorTool(ctx, "add", float64(dice1), float64(dice2))
+	// 使用请求的 Rand，相同种子得到相同的骰子点数
+	rng := random.FromContext(ctx)
+	span.SetAttributes(attribute.Int64(random.SeedAttribute, rng.Seed()))
+	evaluator := &dice.Evaluator{Rand: rng, Combine: mcpCombine}
+	result, err := evaluator.Eval(mcp.WithBudget(ctx, mcpRollBudget), expr)
 	if err != nil {
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "MCP计算失败")
-		log.Printf("MCP计算失败，使用本地计算: %v", err)
-		// 如果MCP调用失败，使用本地计算作为回退
-		number = dice1 + dice2
-	} else {
-		number = int(result)
+		if isInvalidExpression(err) {
+			span.SetStatus(codes.Error, "invalid dice expression")
+		} else {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return nil, err
 	}
 
-	span.SetAttributes(attribute.Int("final_number", number))
-	rollValueAttr := attribute.Int("roll.value", number)
+	log.Printf("掷骰结果: %s = %d", expr, result.Total)
+
+	span.SetAttributes(attribute.Int("final_number", result.Total))
+	rollValueAttr := attribute.Int("roll.value", result.Total)
 	rollCnt.Add(ctx, 1, metric.WithAttributes(rollValueAttr))
 
 	if err = redis.DoSomething(ctx, redis.Rdb); err != nil {
@@ -115,10 +201,10 @@ func rollOnce(ctx context.Context) (int, error) {
 		// 记录错误详情
 		span.RecordError(err, gotrace.WithStackTrace(true))
 		log.Printf("doSomething failed:%v\n!", err)
-		return number, err
+		return result, err
 	}
 
-	logx.Logger.InfoContext(ctx, fmt.Sprintf("rollOnce number:%d (通过MCP计算: %d + %d)", number, dice1, dice2))
+	logx.Logger.InfoContext(ctx, fmt.Sprintf("rollOnce number:%d (表达式: %s)", result.Total, expr))
 
-	return number, nil
+	return result, nil
 }
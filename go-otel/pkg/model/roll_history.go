@@ -0,0 +1,414 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/dice"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/random"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+// rollHistoryKey 是保存掷骰历史的 sorted set，score 为毫秒时间戳。
+const rollHistoryKey = "go-demo:rolls"
+
+const (
+	defaultRollPageSize = 50
+	maxRollPageSize     = 500
+	defaultStatsWindow  = 24 * time.Hour
+	// 统计接口最多读取的记录数
+	maxStatsRecords = 20000
+)
+
+// RollRecord 是一次掷骰的历史记录。
+type RollRecord struct {
+	Id         string            `json:"id"`
+	Caller     string            `json:"caller"`
+	Expression string            `json:"expression"`
+	Total      int               `json:"total"`
+	Rolls      []dice.RollResult `json:"rolls"`
+	TraceId    string            `json:"trace_id,omitempty"`
+	// Seed 是本次请求的随机种子，通过 X-Random-Seed 请求头可重放
+	Seed int64     `json:"seed,string"`
+	At   time.Time `json:"at"`
+}
+
+// rollHistoryMax 返回保留的历史记录条数，可通过 ROLL_HISTORY_MAX 配置。
+func rollHistoryMax() int64 {
+	return int64(envInt("ROLL_HISTORY_MAX", 10000))
+}
+
+// callerFromRequest 返回掷骰的调用方：优先使用 X-Actor 请求头，否则使用客户端 IP。
+func callerFromRequest(c *gin.Context) string {
+	if actor := c.GetHeader(actorHeader); actor != "" {
+		return actor
+	}
+	return c.ClientIP()
+}
+
+// saveRoll 把一次掷骰写入历史，并裁剪到 rollHistoryMax 条，同时发布到实时流。
+func saveRoll(ctx context.Context, caller string, result *dice.Result) error {
+	rec := RollRecord{
+		Id:         uuid.NewString(),
+		Caller:     caller,
+		Expression: result.Expression,
+		Total:      result.Total,
+		Rolls:      result.Rolls,
+		Seed:       random.FromContext(ctx).Seed(),
+		At:         time.Now().UTC(),
+	}
+	if sc := gotrace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		rec.TraceId = sc.TraceID().String()
+	}
+	member, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	event, err := newRollEvent(ctx, rec)
+	if err != nil {
+		return err
+	}
+	pipe := redis.Rdb.TxPipeline()
+	pipe.ZAdd(ctx, rollHistoryKey, goredis.Z{Score: float64(rec.At.UnixMilli()), Member: member})
+	pipe.ZRemRangeByRank(ctx, rollHistoryKey, 0, -rollHistoryMax()-1)
+	// 同时广播给所有实例的 /rolls/stream 和 /ws 订阅者
+	pipe.Publish(ctx, rollEventsChannel, event)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// loadRolls 按时间倒序读取 (max, min] 区间内至多 limit 条记录。
+func loadRolls(ctx context.Context, min, max string, limit int64) ([]RollRecord, error) {
+	zs, err := redis.Rdb.ZRevRangeByScore(ctx, rollHistoryKey, &goredis.ZRangeBy{
+		Min:   min,
+		Max:   max,
+		Count: limit,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]RollRecord, 0, len(zs))
+	for _, z := range zs {
+		var rec RollRecord
+		if err := json.Unmarshal([]byte(z), &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// rollCursor 是 /rolls 的翻页位置：从 score（毫秒时间戳）开始，跳过该 score 下已经返回的 offset 条。
+// 同一毫秒内可能有多条记录，只用时间戳作为游标会漏掉它们。
+type rollCursor struct {
+	score  int64
+	offset int64
+}
+
+// parseRollCursor 解析 "<score>:<offset>" 形式的游标。
+func parseRollCursor(s string) (rollCursor, error) {
+	scorePart, offsetPart, ok := strings.Cut(s, ":")
+	if !ok {
+		return rollCursor{}, fmt.Errorf("cursor %q: expected <score>:<offset>", s)
+	}
+	score, err := strconv.ParseInt(scorePart, 10, 64)
+	if err != nil {
+		return rollCursor{}, err
+	}
+	offset, err := strconv.ParseInt(offsetPart, 10, 64)
+	if err != nil || offset < 0 {
+		return rollCursor{}, fmt.Errorf("cursor %q: offset must be a non-negative integer", s)
+	}
+	return rollCursor{score: score, offset: offset}, nil
+}
+
+func (c rollCursor) String() string {
+	return strconv.FormatInt(c.score, 10) + ":" + strconv.FormatInt(c.offset, 10)
+}
+
+// ListRolls 处理 GET /rolls，按时间倒序分页返回掷骰历史。
+// 翻页时把上一页返回的 next_cursor 作为 cursor 参数传入。
+func ListRolls(c *gin.Context) {
+	limit := defaultRollPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxRollPageSize {
+			p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+			p.InvalidParams = []FieldError{{Name: "limit", Reason: "must be between 1 and " + strconv.Itoa(maxRollPageSize)}}
+			writeProblem(c, p, err)
+			return
+		}
+		limit = n
+	}
+	max := "+inf"
+	var cursor *rollCursor
+	if v := c.Query("cursor"); v != "" {
+		cur, err := parseRollCursor(v)
+		if err != nil {
+			p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+			p.InvalidParams = []FieldError{{Name: "cursor", Reason: "must be a cursor returned by a previous page"}}
+			writeProblem(c, p, err)
+			return
+		}
+		// 包含 cursor 的 score，再用 offset 跳过上一页已经返回的同一毫秒的记录
+		cursor = &cur
+		max = strconv.FormatInt(cur.score, 10)
+	}
+	by := &goredis.ZRangeBy{Min: "-inf", Max: max, Count: int64(limit)}
+	if cursor != nil {
+		by.Offset = cursor.offset
+	}
+
+	zs, err := redis.Rdb.ZRevRangeByScoreWithScores(c.Request.Context(), rollHistoryKey, by).Result()
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "redis-error", "failed to read roll history"), err)
+		return
+	}
+	records := make([]RollRecord, 0, len(zs))
+	for _, z := range zs {
+		var rec RollRecord
+		if member, ok := z.Member.(string); !ok || json.Unmarshal([]byte(member), &rec) != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	resp := gin.H{"items": records}
+	if len(zs) == limit {
+		// offset 按原始成员计数，无法解析而被跳过的记录也要算上
+		next := rollCursor{score: int64(zs[len(zs)-1].Score)}
+		for _, z := range zs {
+			if int64(z.Score) == next.score {
+				next.offset++
+			}
+		}
+		if cursor != nil && cursor.score == next.score {
+			next.offset += cursor.offset
+		}
+		resp["next_cursor"] = next.String()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// FairnessTest 是某种面数骰子的卡方均匀性检验结果。
+type FairnessTest struct {
+	Sides      int         `json:"sides"`
+	Samples    int         `json:"samples"`
+	Observed   map[int]int `json:"observed"`
+	Expected   float64     `json:"expected"`
+	ChiSquare  float64     `json:"chi_square"`
+	Degrees    int         `json:"degrees_of_freedom"`
+	PValue     float64     `json:"p_value"`
+	Suspicious bool        `json:"suspicious"`
+}
+
+// RollStats 是 GET /rolls/stats 的响应体。
+type RollStats struct {
+	Since        time.Time      `json:"since"`
+	Expression   string         `json:"expression,omitempty"`
+	Count        int            `json:"count"`
+	Mean         float64        `json:"mean"`
+	StdDev       float64        `json:"stddev"`
+	Min          *RollRecord    `json:"min,omitempty"`
+	Max          *RollRecord    `json:"max,omitempty"`
+	Distribution map[int]int    `json:"distribution"`
+	PerHour      map[string]int `json:"per_hour"`
+	Fairness     []FairnessTest `json:"fairness"`
+}
+
+// RollStatsHandler 处理 GET /rolls/stats：统计时间窗口内的分布、均值、每小时次数，
+// 并对每种面数的单个骰子做卡方均匀性检验。min/max 带有 trace_id，可以直接跳转到对应链路。
+func RollStatsHandler(c *gin.Context) {
+	ctx, span := otel.Tracer("roll").Start(c.Request.Context(), "rolls.stats")
+	defer span.End()
+
+	window := defaultStatsWindow
+	if v := c.Query("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+			p.InvalidParams = []FieldError{{Name: "window", Reason: "must be a positive duration such as 1h"}}
+			writeProblem(c, p, err)
+			return
+		}
+		window = d
+	}
+	expr := c.Query("expr")
+	since := time.Now().Add(-window).UTC()
+
+	records, err := loadRolls(ctx, strconv.FormatInt(since.UnixMilli(), 10), "+inf", maxStatsRecords)
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "redis-error", "failed to read roll history"), err)
+		return
+	}
+	if expr != "" {
+		filtered := records[:0]
+		for _, r := range records {
+			if r.Expression == expr {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+	}
+
+	stats := computeRollStats(records)
+	stats.Since = since
+	stats.Expression = expr
+	span.SetAttributes(
+		attribute.Int("rolls.stats.count", stats.Count),
+		attribute.Float64("rolls.stats.mean", stats.Mean),
+		attribute.String("rolls.stats.window", window.String()),
+	)
+	c.JSON(http.StatusOK, stats)
+}
+
+func computeRollStats(records []RollRecord) *RollStats {
+	stats := &RollStats{
+		Count:        len(records),
+		Distribution: map[int]int{},
+		PerHour:      map[string]int{},
+		Fairness:     []FairnessTest{},
+	}
+	// faces[sides][value] 统计单个骰子第一次掷出的点数
+	faces := map[int]map[int]int{}
+	sum, sumSq := 0.0, 0.0
+	for i := range records {
+		r := &records[i]
+		stats.Distribution[r.Total]++
+		stats.PerHour[r.At.Truncate(time.Hour).Format(time.RFC3339)]++
+		sum += float64(r.Total)
+		sumSq += float64(r.Total) * float64(r.Total)
+		if stats.Min == nil || r.Total < stats.Min.Total {
+			stats.Min = r
+		}
+		if stats.Max == nil || r.Total > stats.Max.Total {
+			stats.Max = r
+		}
+		for _, roll := range r.Rolls {
+			sides := notationSides(roll.Notation)
+			if sides < 2 {
+				continue
+			}
+			if faces[sides] == nil {
+				faces[sides] = map[int]int{}
+			}
+			for _, d := range roll.Dice {
+				// 爆炸过的骰子第一次必然掷出了最大值，追加的点数不参与统计
+				if d.Explosions > 0 {
+					faces[sides][sides]++
+				} else {
+					faces[sides][d.Value]++
+				}
+			}
+		}
+	}
+	if stats.Count > 0 {
+		n := float64(stats.Count)
+		stats.Mean = sum / n
+		stats.StdDev = math.Sqrt(math.Max(sumSq/n-stats.Mean*stats.Mean, 0))
+	}
+
+	sidesList := make([]int, 0, len(faces))
+	for sides := range faces {
+		sidesList = append(sidesList, sides)
+	}
+	sort.Ints(sidesList)
+	for _, sides := range sidesList {
+		stats.Fairness = append(stats.Fairness, chiSquareUniform(sides, faces[sides]))
+	}
+	return stats
+}
+
+// notationSides 从 "4d6kh3" 这样的记法中取出面数。
+func notationSides(notation string) int {
+	ast, err := dice.Parse(notation)
+	if err != nil {
+		return 0
+	}
+	if r, ok := ast.(*dice.Roll); ok {
+		return r.Sides
+	}
+	return 0
+}
+
+// chiSquareUniform 检验 observed 是否符合 1..sides 上的均匀分布，p < 0.01 视为可疑。
+func chiSquareUniform(sides int, observed map[int]int) FairnessTest {
+	t := FairnessTest{Sides: sides, Observed: observed, Degrees: sides - 1}
+	for _, n := range observed {
+		t.Samples += n
+	}
+	if t.Samples == 0 {
+		return t
+	}
+	t.Expected = float64(t.Samples) / float64(sides)
+	for v := 1; v <= sides; v++ {
+		diff := float64(observed[v]) - t.Expected
+		t.ChiSquare += diff * diff / t.Expected
+	}
+	t.PValue = chiSquareSurvival(t.ChiSquare, t.Degrees)
+	// 期望频数太小时卡方近似不可靠，不做判断
+	t.Suspicious = t.Expected >= 5 && t.PValue < 0.01
+	return t
+}
+
+// chiSquareSurvival 返回自由度为 k 的卡方分布 P(X >= x)，即正则化上不完全 Gamma 函数 Q(k/2, x/2)。
+func chiSquareSurvival(x float64, k int) float64 {
+	if x <= 0 || k <= 0 {
+		return 1
+	}
+	return upperIncompleteGamma(float64(k)/2, x/2)
+}
+
+// upperIncompleteGamma 计算 Q(a, x)，x < a+1 时用级数，否则用连分式。
+func upperIncompleteGamma(a, x float64) float64 {
+	lgamma, _ := math.Lgamma(a)
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < 500; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-14 {
+				break
+			}
+		}
+		return 1 - sum*math.Exp(-x+a*math.Log(x)-lgamma)
+	}
+	// Lentz 算法求连分式
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < 500; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-14 {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lgamma) * h
+}
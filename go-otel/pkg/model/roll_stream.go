@@ -0,0 +1,494 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/dice"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+// rollEventsChannel 是广播掷骰事件的 Redis Pub/Sub 频道，多实例部署时每个实例都能收到全部掷骰。
+const rollEventsChannel = "go-demo:rolls:events"
+
+const (
+	// 每个订阅者的缓冲区大小，缓冲区满时丢弃新事件
+	defaultRollStreamBuffer = 64
+	// 连续丢弃超过该数量仍未消费，视为慢消费者并断开
+	defaultRollStreamMaxLag = 1000
+	// 单次写入的超时，TCP 窗口被写满的客户端会在超时后断开
+	defaultRollStreamWriteTimeout   = 10 * time.Second
+	defaultRollStreamMaxSubscribers = 1000
+	rollStreamHeartbeat             = 15 * time.Second
+	// rollStreamMaxClientMessage 是 /ws 读取客户端消息的上限，客户端消息会被丢弃
+	rollStreamMaxClientMessage = 64 << 10
+)
+
+const (
+	transportSSE       = "sse"
+	transportWebSocket = "websocket"
+)
+
+var (
+	rollStreamSubscribers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "roll_stream_subscribers",
+		Help: "The number of connected roll stream subscribers",
+	}, []string{"transport"})
+	rollStreamDelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "roll_stream_delivered_total",
+		Help: "The total number of rolls delivered to stream subscribers",
+	}, []string{"transport"})
+	rollStreamDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "roll_stream_dropped_total",
+		Help: "The total number of rolls dropped because a subscriber was too slow",
+	}, []string{"transport"})
+	rollStreamDisconnected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "roll_stream_slow_consumer_disconnects_total",
+		Help: "The total number of subscribers disconnected for falling too far behind",
+	}, []string{"transport"})
+)
+
+func init() {
+	prometheus.MustRegister(rollStreamSubscribers, rollStreamDelivered, rollStreamDropped, rollStreamDisconnected)
+}
+
+// rollEvent 是通过 Pub/Sub 广播的掷骰事件，Carrier 是产生这次掷骰的 span 的 trace context。
+type rollEvent struct {
+	Roll    RollRecord             `json:"roll"`
+	Carrier propagation.MapCarrier `json:"carrier,omitempty"`
+}
+
+func newRollEvent(ctx context.Context, rec RollRecord) ([]byte, error) {
+	ev := rollEvent{Roll: rec, Carrier: propagation.MapCarrier{}}
+	otel.GetTextMapPropagator().Inject(ctx, ev.Carrier)
+	return json.Marshal(ev)
+}
+
+// rollFilter 是订阅者在服务端的过滤条件。
+type rollFilter struct {
+	// expr 是规范化后的表达式，为空表示不过滤
+	expr     string
+	minTotal *int
+	maxTotal *int
+}
+
+// normalizeExpr 与 dice.Parse 一样忽略空白和大小写，使 "1d6 + 1d6" 与 "1D6+1d6" 匹配。
+func normalizeExpr(expr string) string {
+	return strings.ToLower(strings.Join(strings.Fields(expr), ""))
+}
+
+// parseRollFilter 从 expr、min_total、max_total 查询参数解析过滤条件。
+func parseRollFilter(c *gin.Context) (rollFilter, []FieldError) {
+	var (
+		f    rollFilter
+		errs []FieldError
+	)
+	if v := c.Query("expr"); v != "" {
+		if _, err := dice.Parse(v); err != nil {
+			errs = append(errs, FieldError{Name: "expr", Reason: err.Error()})
+		}
+		f.expr = normalizeExpr(v)
+	}
+	for _, p := range []struct {
+		name string
+		dst  **int
+	}{{"min_total", &f.minTotal}, {"max_total", &f.maxTotal}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, FieldError{Name: p.name, Reason: "must be an integer"})
+			continue
+		}
+		*p.dst = &n
+	}
+	if f.minTotal != nil && f.maxTotal != nil && *f.minTotal > *f.maxTotal {
+		errs = append(errs, FieldError{Name: "min_total", Reason: "must not be greater than max_total"})
+	}
+	return f, errs
+}
+
+func (f rollFilter) match(rec *RollRecord) bool {
+	if f.expr != "" && normalizeExpr(rec.Expression) != f.expr {
+		return false
+	}
+	if f.minTotal != nil && rec.Total < *f.minTotal {
+		return false
+	}
+	if f.maxTotal != nil && rec.Total > *f.maxTotal {
+		return false
+	}
+	return true
+}
+
+type rollSubscriber struct {
+	transport string
+	filter    rollFilter
+	ch        chan *rollEvent
+	// lag 是自上次取出事件以来因缓冲区满而丢弃的事件数
+	lag atomic.Int64
+	// overflowed 在因过慢被断开时置为 true，在关闭 ch 之前写入
+	overflowed bool
+}
+
+// rollHub 把本实例收到的掷骰事件分发给所有订阅者。
+type rollHub struct {
+	mu   sync.Mutex
+	subs map[*rollSubscriber]struct{}
+}
+
+var rollStreamHub = &rollHub{subs: map[*rollSubscriber]struct{}{}}
+
+// subscribe 注册一个订阅者，订阅者数量达到上限时返回 false。
+func (h *rollHub) subscribe(transport string, filter rollFilter) (*rollSubscriber, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) >= envInt("ROLL_STREAM_MAX_SUBSCRIBERS", defaultRollStreamMaxSubscribers) {
+		return nil, false
+	}
+	s := &rollSubscriber{
+		transport: transport,
+		filter:    filter,
+		ch:        make(chan *rollEvent, envInt("ROLL_STREAM_BUFFER", defaultRollStreamBuffer)),
+	}
+	h.subs[s] = struct{}{}
+	rollStreamSubscribers.WithLabelValues(transport).Inc()
+	return s, true
+}
+
+// unsubscribe 移除订阅者，可以重复调用。
+func (h *rollHub) unsubscribe(s *rollSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(s)
+}
+
+func (h *rollHub) removeLocked(s *rollSubscriber) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	close(s.ch)
+	rollStreamSubscribers.WithLabelValues(s.transport).Dec()
+}
+
+// broadcast 非阻塞地把事件发给每个匹配的订阅者：缓冲区满时丢弃并记入 lag，
+// 积压超过 ROLL_STREAM_MAX_LAG 的订阅者被断开，慢消费者不会拖慢其他订阅者。
+func (h *rollHub) broadcast(ev *rollEvent) {
+	maxLag := int64(envInt("ROLL_STREAM_MAX_LAG", defaultRollStreamMaxLag))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if !s.filter.match(&ev.Roll) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			rollStreamDropped.WithLabelValues(s.transport).Inc()
+			if s.lag.Add(1) > maxLag {
+				s.overflowed = true
+				h.removeLocked(s)
+				rollStreamDisconnected.WithLabelValues(s.transport).Inc()
+			}
+		}
+	}
+}
+
+// StartRollStream 订阅 rollEventsChannel，把收到的事件分发给本实例的订阅者，ctx 结束时退出。
+func StartRollStream(ctx context.Context) {
+	go func() {
+		pubsub := redis.Rdb.Subscribe(ctx, rollEventsChannel)
+		defer pubsub.Close()
+		msgs := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				var ev rollEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+					log.Printf("decode roll event failed: %v", err)
+					continue
+				}
+				rollStreamHub.broadcast(&ev)
+			}
+		}
+	}()
+}
+
+// rollSink 是一种推送方式（SSE 或 WebSocket）。
+type rollSink interface {
+	roll(ev *rollEvent) error
+	lag(dropped int64) error
+	overflow() error
+	heartbeat() error
+}
+
+// pumpRolls 把订阅者收到的事件写给客户端，直到 ctx 结束、写入失败或订阅者因过慢被断开。
+func pumpRolls(ctx context.Context, sub *rollSubscriber, sink rollSink) {
+	ticker := time.NewTicker(rollStreamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sink.heartbeat(); err != nil {
+				return
+			}
+		case ev, ok := <-sub.ch:
+			if !ok {
+				if sub.overflowed {
+					_ = sink.overflow()
+				}
+				return
+			}
+			// 先告诉客户端中间丢了多少条，再发送当前事件
+			if n := sub.lag.Swap(0); n > 0 {
+				if err := sink.lag(n); err != nil {
+					return
+				}
+			}
+			if err := deliverRoll(ctx, sub, sink, ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// deliverRoll 为每次投递创建 consumer span。订阅连接可能持续数小时，所以投递 span 是新的 root，
+// 通过 link 关联到产生这次掷骰的 span 和订阅请求的 span。
+func deliverRoll(ctx context.Context, sub *rollSubscriber, sink rollSink, ev *rollEvent) error {
+	var links []gotrace.Link
+	producer := gotrace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), ev.Carrier))
+	if producer.IsValid() {
+		links = append(links, gotrace.Link{
+			SpanContext: producer,
+			Attributes:  []attribute.KeyValue{attribute.String("link.role", "producer")},
+		})
+	}
+	if subscription := gotrace.SpanContextFromContext(ctx); subscription.IsValid() {
+		links = append(links, gotrace.Link{
+			SpanContext: subscription,
+			Attributes:  []attribute.KeyValue{attribute.String("link.role", "subscription")},
+		})
+	}
+
+	_, span := otel.Tracer("roll").Start(context.Background(), "rolls.stream deliver",
+		gotrace.WithNewRoot(),
+		gotrace.WithSpanKind(gotrace.SpanKindConsumer),
+		gotrace.WithLinks(links...),
+		gotrace.WithAttributes(
+			attribute.String("messaging.system", "redis"),
+			attribute.String("messaging.destination.name", rollEventsChannel),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("roll.stream.transport", sub.transport),
+			attribute.String("roll.id", ev.Roll.Id),
+			attribute.String("dice.expression", ev.Roll.Expression),
+			attribute.Int("dice.total", ev.Roll.Total),
+		))
+	defer span.End()
+
+	if err := sink.roll(ev); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "deliver roll failed")
+		return err
+	}
+	rollStreamDelivered.WithLabelValues(sub.transport).Inc()
+	return nil
+}
+
+func rollStreamWriteTimeout() time.Duration {
+	return envDuration("ROLL_STREAM_WRITE_TIMEOUT", defaultRollStreamWriteTimeout)
+}
+
+// subscribeRolls 解析过滤条件并注册订阅者，失败时已写回 problem。
+func subscribeRolls(c *gin.Context, transport string) (*rollSubscriber, bool) {
+	filter, errs := parseRollFilter(c)
+	if len(errs) > 0 {
+		p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+		p.InvalidParams = errs
+		writeProblem(c, p, nil)
+		return nil, false
+	}
+	sub, ok := rollStreamHub.subscribe(transport, filter)
+	if !ok {
+		c.Header("Retry-After", "5")
+		writeProblem(c, newProblem(http.StatusServiceUnavailable, "too-many-subscribers", "roll stream subscriber limit reached"), nil)
+		return nil, false
+	}
+	return sub, true
+}
+
+type sseSink struct {
+	w       gin.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (s *sseSink) write(frame string) error {
+	_ = s.rc.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.w.WriteString(frame); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *sseSink) event(name, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", name, data)
+	return s.write(b.String())
+}
+
+func (s *sseSink) roll(ev *rollEvent) error { return s.event("roll", ev.Roll.Id, ev.Roll) }
+
+func (s *sseSink) lag(dropped int64) error {
+	return s.event("lag", "", gin.H{"dropped": dropped})
+}
+
+func (s *sseSink) overflow() error {
+	return s.event("overflow", "", gin.H{"reason": "consumer too slow"})
+}
+
+func (s *sseSink) heartbeat() error { return s.write(": ping\n\n") }
+
+// StreamRolls 处理 GET /rolls/stream，以 Server-Sent Events 推送实时掷骰。
+// 支持 expr、min_total、max_total 过滤；事件类型为 roll、lag（丢弃了多少条）和 overflow（因过慢被断开）。
+func StreamRolls(c *gin.Context) {
+	sub, ok := subscribeRolls(c, transportSSE)
+	if !ok {
+		return
+	}
+	defer rollStreamHub.unsubscribe(sub)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// 关闭 nginx 等反向代理的缓冲
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	sink := &sseSink{w: c.Writer, rc: http.NewResponseController(c.Writer), timeout: rollStreamWriteTimeout()}
+	if err := sink.write("retry: 3000\n\n"); err != nil {
+		return
+	}
+	pumpRolls(c.Request.Context(), sub, sink)
+}
+
+// wsMessage 是 /ws 推送的消息。
+type wsMessage struct {
+	Type    string      `json:"type"`
+	Roll    *RollRecord `json:"roll,omitempty"`
+	Dropped int64       `json:"dropped,omitempty"`
+}
+
+type wsSink struct {
+	ctx     context.Context
+	conn    *websocket.Conn
+	timeout time.Duration
+}
+
+func (s *wsSink) send(m wsMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	return s.conn.Write(ctx, websocket.MessageText, data)
+}
+
+func (s *wsSink) roll(ev *rollEvent) error { return s.send(wsMessage{Type: "roll", Roll: &ev.Roll}) }
+
+func (s *wsSink) lag(dropped int64) error { return s.send(wsMessage{Type: "lag", Dropped: dropped}) }
+
+func (s *wsSink) overflow() error {
+	_ = s.send(wsMessage{Type: "overflow"})
+	return s.conn.Close(websocket.StatusTryAgainLater, "consumer too slow")
+}
+
+// heartbeat 发送 ping 并等待 pong，对端在写超时内没有回应时视为已断开
+func (s *wsSink) heartbeat() error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	return s.conn.Ping(ctx)
+}
+
+// wsOriginPatterns 返回允许跨域连接 /ws 的 Origin 主机名模式，可通过 WS_ALLOWED_ORIGINS 配置（逗号分隔，
+// 支持 path.Match 通配符，如 "*.example.com"）。未配置时只接受与请求 Host 相同的 Origin。
+func wsOriginPatterns() []string {
+	var patterns []string
+	for _, p := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// RollsWebSocket 处理 /ws，通过 WebSocket 推送实时掷骰，过滤参数与 /rolls/stream 相同。
+// 消息为 {"type":"roll","roll":{...}}、{"type":"lag","dropped":n} 或 {"type":"overflow"}。
+// 浏览器发起的跨域连接只有 Origin 在 WS_ALLOWED_ORIGINS 中时才接受。
+func RollsWebSocket(c *gin.Context) {
+	sub, ok := subscribeRolls(c, transportWebSocket)
+	if !ok {
+		return
+	}
+	defer rollStreamHub.unsubscribe(sub)
+
+	// Origin 不被允许时 Accept 已写回 403
+	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{OriginPatterns: wsOriginPatterns()})
+	if err != nil {
+		gotrace.SpanFromContext(c.Request.Context()).RecordError(err)
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	conn.SetReadLimit(rollStreamMaxClientMessage)
+
+	// 连接被接管后请求的 context 不再感知客户端断开，由读循环负责：
+	// 它处理 ping/pong 和关闭帧，丢弃客户端发来的消息，读出错时结束推送
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.Read(ctx); err != nil {
+				return
+			}
+		}
+	}()
+
+	pumpRolls(ctx, sub, &wsSink{ctx: ctx, conn: conn, timeout: rollStreamWriteTimeout()})
+}
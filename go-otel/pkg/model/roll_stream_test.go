@@ -0,0 +1,139 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+func newWebSocketServer(t *testing.T) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ws", RollsWebSocket)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func dialRolls(t *testing.T, url, origin string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	opts := &websocket.DialOptions{}
+	if origin != "" {
+		opts.HTTPHeader = http.Header{"Origin": {origin}}
+	}
+	return websocket.Dial(ctx, url, opts)
+}
+
+func TestRollsWebSocketOrigin(t *testing.T) {
+	cases := []struct {
+		name    string
+		origin  string
+		allowed string
+		ok      bool
+	}{
+		{"no origin", "", "", true},
+		{"cross origin", "https://evil.example", "", false},
+		{"allowed origin", "https://app.example.com", "*.example.com", true},
+		{"not in allow list", "https://evil.example", "*.example.com", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WS_ALLOWED_ORIGINS", tc.allowed)
+			conn, resp, err := dialRolls(t, newWebSocketServer(t), tc.origin)
+			if !tc.ok {
+				if err == nil {
+					conn.CloseNow()
+					t.Fatal("cross-origin connection accepted")
+				}
+				if resp == nil || resp.StatusCode != http.StatusForbidden {
+					t.Fatalf("resp = %v, err = %v, want 403", resp, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			conn.Close(websocket.StatusNormalClosure, "")
+		})
+	}
+}
+
+func TestRollsWebSocketDelivers(t *testing.T) {
+	conn, _, err := dialRolls(t, newWebSocketServer(t), "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	// 等订阅者注册完成再广播
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rollStreamHub.mu.Lock()
+		n := len(rollStreamHub.subs)
+		rollStreamHub.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rollStreamHub.broadcast(&rollEvent{Roll: RollRecord{Id: "r1", Expression: "1d6", Total: 4}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	typ, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var m wsMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	if typ != websocket.MessageText || m.Type != "roll" || m.Roll == nil || m.Roll.Id != "r1" {
+		t.Errorf("got %v %s", typ, data)
+	}
+}
+
+// 不回应 pong 的对端应在写超时内被判定为断开
+func TestWebSocketHeartbeatDetectsDeadPeer(t *testing.T) {
+	result := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			result <- err
+			return
+		}
+		defer conn.CloseNow()
+		sink := &wsSink{ctx: r.Context(), conn: conn, timeout: 100 * time.Millisecond}
+		result <- sink.heartbeat()
+	}))
+	defer srv.Close()
+
+	// 客户端从不读取，收不到 ping 也就不会回复 pong
+	conn, _, err := dialRolls(t, "ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("heartbeat succeeded without a pong")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat did not time out")
+	}
+}
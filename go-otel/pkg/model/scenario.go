@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+)
+
+// ScenarioHeader 指定本次请求中 DoSomething 执行的 Redis 场景。
+const ScenarioHeader = "X-Redis-Scenario"
+
+// RedisScenario 按 X-Redis-Scenario 请求头选择 Redis 场景，未知的场景返回 400。
+func RedisScenario() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.GetHeader(ScenarioHeader)
+		if name == "" {
+			c.Next()
+			return
+		}
+		if !redis.HasScenario(name) {
+			p := newProblem(http.StatusBadRequest, "validation-error", fmt.Sprintf("unknown redis scenario %q", name))
+			p.InvalidParams = []FieldError{{Name: ScenarioHeader, Reason: "must be one of " + strings.Join(redis.Scenarios(), ", ")}}
+			writeProblem(c, p, nil)
+			return
+		}
+		ctx := c.Request.Context()
+		gotrace.SpanFromContext(ctx).SetAttributes(attribute.String(redis.ScenarioAttribute, name))
+		c.Request = c.Request.WithContext(redis.WithScenario(ctx, name))
+		c.Next()
+	}
+}
@@ -0,0 +1,453 @@
+package model
+
+import (
+	"context"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// 少于这个长度的查询不做模糊匹配，否则几乎所有名字都会命中
+	minFuzzyQueryLen = 3
+	// 电话后缀匹配至少需要的数字位数
+	minPhoneSuffixLen = 3
+
+	highlightPre  = "<em>"
+	highlightPost = "</em>"
+)
+
+// 匹配类型，分数越高排序越靠前
+const (
+	matchExact       = "exact"
+	matchPrefix      = "prefix"
+	matchPhoneSuffix = "phone_suffix"
+	matchFuzzy       = "fuzzy"
+)
+
+var matchBaseScore = map[string]float64{
+	matchExact:       100,
+	matchPrefix:      80,
+	matchPhoneSuffix: 70,
+	matchFuzzy:       60,
+}
+
+// SearchHit 是搜索结果中的一条。Highlight 是命中字段的 HTML 片段，匹配部分用 <em> 标出，其余内容已转义。
+type SearchHit struct {
+	User      User              `json:"user"`
+	Score     float64           `json:"score"`
+	Match     string            `json:"match"`
+	Highlight map[string]string `json:"highlight"`
+}
+
+// searchIndex 是用户搜索使用的内存索引，与存储后端无关：
+// 启动时从数据库全量加载，之后随本进程的写操作增量更新，并定期全量刷新以同步其它副本的写入。
+type searchIndex struct {
+	mu    sync.RWMutex
+	users map[int64]User
+	// tokens 是姓名按空白切分、转小写后的词，指向拥有该词的用户
+	tokens map[string]map[int64]struct{}
+	// sortedTokens 是 tokens 的有序 key，用于前缀的二分查找
+	sortedTokens []string
+	// reversedPhones 是按反转后字符串排序的电话，用于后缀的二分查找
+	reversedPhones []phoneEntry
+	// journal 记录全量加载期间的增量更新（nil 表示删除），加载完成后在快照之上重放，
+	// 避免快照读出之后的写入被覆盖；不在加载时为 nil
+	journal map[int64]*User
+}
+
+type phoneEntry struct {
+	reversed string
+	id       int64
+}
+
+var userIndex = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		users:  map[int64]User{},
+		tokens: map[string]map[int64]struct{}{},
+	}
+}
+
+func nameTokens(name string) []string {
+	return strings.Fields(strings.ToLower(name))
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// phoneDigits 只保留电话中的数字，便于与查询中的数字比较。
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// upsert 加入或替换一个用户。
+func (idx *searchIndex) upsert(u User) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.journal != nil {
+		idx.journal[u.Id] = &u
+	}
+	idx.upsertLocked(u)
+}
+
+func (idx *searchIndex) upsertLocked(u User) {
+	idx.removeLocked(u.Id)
+	idx.users[u.Id] = u
+	for _, t := range nameTokens(u.Name) {
+		ids, ok := idx.tokens[t]
+		if !ok {
+			ids = map[int64]struct{}{}
+			idx.tokens[t] = ids
+			i := sort.SearchStrings(idx.sortedTokens, t)
+			idx.sortedTokens = append(idx.sortedTokens, "")
+			copy(idx.sortedTokens[i+1:], idx.sortedTokens[i:])
+			idx.sortedTokens[i] = t
+		}
+		ids[u.Id] = struct{}{}
+	}
+	e := phoneEntry{reversed: reverse(phoneDigits(u.Phone)), id: u.Id}
+	i := sort.Search(len(idx.reversedPhones), func(i int) bool { return idx.reversedPhones[i].reversed >= e.reversed })
+	idx.reversedPhones = append(idx.reversedPhones, phoneEntry{})
+	copy(idx.reversedPhones[i+1:], idx.reversedPhones[i:])
+	idx.reversedPhones[i] = e
+}
+
+// remove 删除一个用户。
+func (idx *searchIndex) remove(id int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.journal != nil {
+		idx.journal[id] = nil
+	}
+	idx.removeLocked(id)
+}
+
+func (idx *searchIndex) removeLocked(id int64) {
+	u, ok := idx.users[id]
+	if !ok {
+		return
+	}
+	delete(idx.users, id)
+	for _, t := range nameTokens(u.Name) {
+		ids := idx.tokens[t]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx.tokens, t)
+			if i := sort.SearchStrings(idx.sortedTokens, t); i < len(idx.sortedTokens) && idx.sortedTokens[i] == t {
+				idx.sortedTokens = append(idx.sortedTokens[:i], idx.sortedTokens[i+1:]...)
+			}
+		}
+	}
+	for i, e := range idx.reversedPhones {
+		if e.id == id {
+			idx.reversedPhones = append(idx.reversedPhones[:i], idx.reversedPhones[i+1:]...)
+			break
+		}
+	}
+}
+
+// beginLoad 在读取快照之前调用，开始记录增量更新。
+func (idx *searchIndex) beginLoad() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.journal = map[int64]*User{}
+}
+
+// endLoad 停止记录增量更新，加载失败时也要调用。
+func (idx *searchIndex) endLoad() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.journal = nil
+}
+
+// replaceAll 用 users 全量替换索引内容，beginLoad 之后的增量更新在快照之上重放。
+func (idx *searchIndex) replaceAll(users []User) {
+	fresh := newSearchIndex()
+	for _, u := range users {
+		fresh.upsertLocked(u)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for id, u := range idx.journal {
+		if u == nil {
+			fresh.removeLocked(id)
+		} else {
+			fresh.upsertLocked(*u)
+		}
+	}
+	idx.users, idx.tokens = fresh.users, fresh.tokens
+	idx.sortedTokens, idx.reversedPhones = fresh.sortedTokens, fresh.reversedPhones
+	idx.journal = nil
+}
+
+// search 返回按分数降序排列的至多 limit 条结果。
+func (idx *searchIndex) search(q string, limit int) []SearchHit {
+	q = strings.ToLower(strings.TrimSpace(q))
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	best := map[int64]SearchHit{}
+	consider := func(id int64, match, field, highlighted string, penalty float64) {
+		score := matchBaseScore[match] - penalty
+		if hit, ok := best[id]; ok && hit.Score >= score {
+			return
+		}
+		best[id] = SearchHit{
+			User:      idx.users[id],
+			Score:     score,
+			Match:     match,
+			Highlight: map[string]string{field: highlighted},
+		}
+	}
+
+	// 姓名：完整匹配、词前缀匹配
+	qTokens := nameTokens(q)
+	if len(qTokens) > 0 {
+		last := qTokens[len(qTokens)-1]
+		for i := sort.SearchStrings(idx.sortedTokens, last); i < len(idx.sortedTokens) && strings.HasPrefix(idx.sortedTokens[i], last); i++ {
+			tok := idx.sortedTokens[i]
+			for id := range idx.tokens[tok] {
+				name := idx.users[id].Name
+				if strings.ToLower(name) == q {
+					consider(id, matchExact, "name", highlight("", name, ""), 0)
+					continue
+				}
+				if !strings.HasPrefix(strings.ToLower(name), q) && len(qTokens) > 1 {
+					continue
+				}
+				// 前缀越接近完整的词，分数越高
+				penalty := float64(len([]rune(tok))-len([]rune(last))) / float64(len([]rune(tok))) * 10
+				consider(id, matchPrefix, "name", highlightToken(name, tok, len([]rune(last))), penalty)
+			}
+		}
+	}
+
+	// 姓名：编辑距离模糊匹配，只在不同的词上计算一次
+	if len([]rune(q)) >= minFuzzyQueryLen && len(qTokens) == 1 {
+		maxDist := 1
+		if len([]rune(q)) >= 6 {
+			maxDist = 2
+		}
+		for _, tok := range idx.sortedTokens {
+			d := levenshtein(q, tok, maxDist)
+			if d == 0 || d > maxDist {
+				continue
+			}
+			for id := range idx.tokens[tok] {
+				name := idx.users[id].Name
+				consider(id, matchFuzzy, "name", highlightToken(name, tok, len([]rune(tok))), float64(d)*10)
+			}
+		}
+	}
+
+	// 电话后缀
+	if digits := phoneDigits(q); len(digits) >= minPhoneSuffixLen && len(digits) == len(strings.TrimPrefix(q, "+")) {
+		rq := reverse(digits)
+		i := sort.Search(len(idx.reversedPhones), func(i int) bool { return idx.reversedPhones[i].reversed >= rq })
+		for ; i < len(idx.reversedPhones) && strings.HasPrefix(idx.reversedPhones[i].reversed, rq); i++ {
+			id := idx.reversedPhones[i].id
+			consider(id, matchPhoneSuffix, "phone", highlightPhoneSuffix(idx.users[id].Phone, len(digits)), 0)
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(best))
+	for _, h := range best {
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].User.Id > hits[j].User.Id
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// highlightToken 在 name 中找到词 tok，并把它的前 n 个字符用高亮标签包起来。
+func highlightToken(name, tok string, n int) string {
+	runes := []rune(name)
+	tokRunes := []rune(tok)
+	lower := []rune(strings.ToLower(name))
+	for i := 0; i+len(tokRunes) <= len(lower); i++ {
+		if string(lower[i:i+len(tokRunes)]) != tok {
+			continue
+		}
+		if i > 0 && !unicode.IsSpace(lower[i-1]) {
+			continue
+		}
+		return highlight(string(runes[:i]), string(runes[i:i+n]), string(runes[i+n:]))
+	}
+	return html.EscapeString(name)
+}
+
+// highlightPhoneSuffix 高亮 phone 中最后 n 个数字，电话中的分隔符不计入位数。
+func highlightPhoneSuffix(phone string, n int) string {
+	cut := len(phone)
+	for cut > 0 && n > 0 {
+		cut--
+		if phone[cut] >= '0' && phone[cut] <= '9' {
+			n--
+		}
+	}
+	return highlight(phone[:cut], phone[cut:], "")
+}
+
+// highlight 把 match 用高亮标签包起来。各段都是用户数据，先做 HTML 转义，
+// 结果可以直接作为 HTML 渲染。
+func highlight(before, match, after string) string {
+	return html.EscapeString(before) + highlightPre + html.EscapeString(match) + highlightPost + html.EscapeString(after)
+}
+
+// levenshtein 计算 a 和 b 的编辑距离，超过 max 时提前返回 max+1。
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// searchIndexRefreshInterval 返回全量刷新索引的间隔，可通过 SEARCH_INDEX_REFRESH 配置（如 "1m"）。
+func searchIndexRefreshInterval() time.Duration {
+	if v := os.Getenv("SEARCH_INDEX_REFRESH"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return time.Minute
+}
+
+// loadSearchIndex 从主库全量加载用户到搜索索引。不读只读副本：副本的延迟会让刚创建的用户从索引中消失。
+func loadSearchIndex(ctx context.Context) error {
+	ctx, span := otel.Tracer("user").Start(ctx, "users.search.load_index")
+	defer span.End()
+
+	userIndex.beginLoad()
+	defer userIndex.endLoad()
+	rows, err := db.QueryContext(ctx, "SELECT "+userColumns+" FROM users")
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	userIndex.replaceAll(users)
+	span.SetAttributes(attribute.Int("search.index.users", len(users)))
+	return nil
+}
+
+// StartSearchIndexRefresh 加载搜索索引，并在后台定期全量刷新，ctx 取消后退出。
+func StartSearchIndexRefresh(ctx context.Context) {
+	if err := loadSearchIndex(ctx); err != nil {
+		log.Printf("load search index failed: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(searchIndexRefreshInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := loadSearchIndex(ctx); err != nil {
+					log.Printf("refresh search index failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// SearchUsers 处理 GET /users/search?q=，支持姓名前缀、编辑距离模糊匹配和电话后缀匹配，
+// 结果按匹配程度排序并给出高亮片段。
+func SearchUsers(c *gin.Context) {
+	_, span := otel.Tracer("user").Start(c.Request.Context(), "users.search")
+	defer span.End()
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+		p.InvalidParams = []FieldError{{Name: "q", Reason: "is required"}}
+		writeProblem(c, p, nil)
+		return
+	}
+	limit := defaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+			p.InvalidParams = []FieldError{{Name: "limit", Reason: "must be between 1 and " + strconv.Itoa(maxSearchLimit)}}
+			writeProblem(c, p, err)
+			return
+		}
+		limit = n
+	}
+
+	hits := userIndex.search(q, limit)
+	span.SetAttributes(
+		attribute.String("search.query", q),
+		attribute.Int("search.limit", limit),
+		attribute.Int("search.results", len(hits)),
+	)
+	c.JSON(http.StatusOK, gin.H{"query": q, "results": hits})
+}
@@ -0,0 +1,147 @@
+package model
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"alice", "alice", 2, 0},
+		{"alice", "alise", 2, 1},
+		{"alice", "alce", 2, 1},
+		{"alice", "alicia", 2, 2},
+		{"", "abc", 3, 3},
+		{"张三", "张四", 1, 1},
+		// 超过 max 时返回 max+1
+		{"alice", "bob", 1, 2},
+		{"a", "abcd", 2, 3},
+		{"kitten", "sitting", 2, 3},
+	}
+	for _, tc := range cases {
+		if got := levenshtein(tc.a, tc.b, tc.max); got != tc.want {
+			t.Errorf("levenshtein(%q, %q, %d) = %d, want %d", tc.a, tc.b, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestHighlightToken(t *testing.T) {
+	cases := []struct {
+		name, tok string
+		n         int
+		want      string
+	}{
+		{"Alice Smith", "alice", 3, "<em>Ali</em>ce Smith"},
+		{"Alice Smith", "smith", 5, "Alice <em>Smith</em>"},
+		// 只匹配词首，不匹配词中间
+		{"Bobby Bob", "bob", 3, "<em>Bob</em>by Bob"},
+		{"Jo Bob", "bob", 2, "Jo <em>Bo</em>b"},
+		{"张 三丰", "三丰", 1, "张 <em>三</em>丰"},
+		// 用户数据中的 HTML 必须转义
+		{"<script> Eve", "eve", 3, "&lt;script&gt; <em>Eve</em>"},
+		{"<b>x</b>", "<b>x</b>", 3, "<em>&lt;b&gt;</em>x&lt;/b&gt;"},
+		{"Tom & Jerry", "jerry", 5, "Tom &amp; <em>Jerry</em>"},
+		// 找不到时只转义
+		{"A<B", "zzz", 3, "A&lt;B"},
+	}
+	for _, tc := range cases {
+		if got := highlightToken(tc.name, tc.tok, tc.n); got != tc.want {
+			t.Errorf("highlightToken(%q, %q, %d) = %q, want %q", tc.name, tc.tok, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestHighlightPhoneSuffix(t *testing.T) {
+	cases := []struct {
+		phone string
+		n     int
+		want  string
+	}{
+		{"13812345678", 4, "1381234<em>5678</em>"},
+		{"13812345678", 11, "<em>13812345678</em>"},
+		// 分隔符不计入位数
+		{"138-1234-5678", 6, "138-12<em>34-5678</em>"},
+		{"+86 138 1234 5678", 3, "+86 138 1234 5<em>678</em>"},
+		{"123", 5, "<em>123</em>"},
+		{"<1>234", 3, "&lt;1&gt;<em>234</em>"},
+	}
+	for _, tc := range cases {
+		if got := highlightPhoneSuffix(tc.phone, tc.n); got != tc.want {
+			t.Errorf("highlightPhoneSuffix(%q, %d) = %q, want %q", tc.phone, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestSearchIndexPhoneSuffix(t *testing.T) {
+	idx := newSearchIndex()
+	idx.upsert(User{Id: 1, Name: "Alice", Phone: "138-1234-5678"})
+	idx.upsert(User{Id: 2, Name: "Bob", Phone: "13900005678"})
+	idx.upsert(User{Id: 3, Name: "Carol", Phone: "13900001234"})
+
+	cases := []struct {
+		q    string
+		want map[int64]string
+	}{
+		{"5678", map[int64]string{1: "138-1234-<em>5678</em>", 2: "1390000<em>5678</em>"}},
+		{"345678", map[int64]string{1: "138-12<em>34-5678</em>"}},
+		// 少于 minPhoneSuffixLen 位不做后缀匹配
+		{"78", map[int64]string{}},
+		{"99999", map[int64]string{}},
+	}
+	for _, tc := range cases {
+		hits := idx.search(tc.q, defaultSearchLimit)
+		got := map[int64]string{}
+		for _, h := range hits {
+			if h.Match != matchPhoneSuffix {
+				t.Errorf("search(%q): user %d matched by %s", tc.q, h.User.Id, h.Match)
+			}
+			got[h.User.Id] = h.Highlight["phone"]
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("search(%q) = %v, want %v", tc.q, got, tc.want)
+			continue
+		}
+		for id, want := range tc.want {
+			if got[id] != want {
+				t.Errorf("search(%q) user %d highlight = %q, want %q", tc.q, id, got[id], want)
+			}
+		}
+	}
+}
+
+// 全量加载期间的增量更新不能被快照覆盖
+func TestSearchIndexReplaceAllKeepsConcurrentWrites(t *testing.T) {
+	idx := newSearchIndex()
+	idx.upsert(User{Id: 1, Name: "Alice"})
+	idx.upsert(User{Id: 2, Name: "Bob"})
+
+	idx.beginLoad()
+	// 快照读出之后发生的写入
+	snapshot := []User{{Id: 1, Name: "Alice"}, {Id: 2, Name: "Bob"}}
+	idx.upsert(User{Id: 3, Name: "Carol"})
+	idx.upsert(User{Id: 1, Name: "Alicia"})
+	idx.remove(2)
+	idx.replaceAll(snapshot)
+	idx.endLoad()
+
+	want := map[int64]string{1: "Alicia", 3: "Carol"}
+	if len(idx.users) != len(want) {
+		t.Fatalf("users = %v, want %v", idx.users, want)
+	}
+	for id, name := range want {
+		if idx.users[id].Name != name {
+			t.Errorf("user %d = %q, want %q", id, idx.users[id].Name, name)
+		}
+	}
+	if hits := idx.search("bob", defaultSearchLimit); len(hits) != 0 {
+		t.Errorf("removed user still searchable: %v", hits)
+	}
+
+	// 加载结束后不再记录
+	idx.upsert(User{Id: 4, Name: "Dave"})
+	idx.replaceAll(nil)
+	if len(idx.users) != 0 {
+		t.Errorf("users after replaceAll(nil) = %v", idx.users)
+	}
+}
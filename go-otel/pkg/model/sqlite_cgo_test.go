@@ -0,0 +1,8 @@
+//go:build cgo
+
+package model
+
+import _ "github.com/mattn/go-sqlite3"
+
+// sqliteDriver 是测试使用的 SQLite 驱动名，go-sqlite3 依赖 cgo
+const sqliteDriver = "sqlite3"
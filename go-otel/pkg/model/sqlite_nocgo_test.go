@@ -0,0 +1,6 @@
+//go:build !cgo
+
+package model
+
+// sqliteDriver 为空表示没有可用的 SQLite 驱动，CGO_ENABLED=0 时跳过 SQLite 上的测试
+const sqliteDriver = ""
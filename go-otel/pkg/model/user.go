@@ -1,39 +1,41 @@
 package model
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"log"
 	"os"
+	"strconv"
+	"time"
 	"github.com/gin-gonic/gin"
-	"github.com/XSAM/otelsql"
-	semconv "go.opentelemetry.io/otel/semconv/v1.28.0"
-	"net"
+	"github.com/go-sql-driver/mysql"
+	"net/http"
+)
+
+// MySQL 错误码
+const (
+	mysqlErrDupFieldName = 1060 // ER_DUP_FIELDNAME
+	mysqlErrDupKeyName   = 1061 // ER_DUP_KEYNAME
+	mysqlErrDupEntry     = 1062 // ER_DUP_ENTRY
 )
 
 var db *sql.DB
 
 func Init() {
 	initMysql()
+	initAudit()
 }
 
 func initMysql() {
 	var err error
-	// 你可以用环境变量配置这些参数
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_ADDRESS"),
-		os.Getenv("DB_NAME"))
-	db, err = otelsql.Open("mysql", dsn,
-		otelsql.WithAttributes(
-			semconv.DBSystemMySQL,
-			semconv.ServerAddress(net.JoinHostPort(os.Getenv("DB_ADDRESS"), os.Getenv("DB_PORT"))),
-			semconv.DBNamespace(os.Getenv("DB_NAME"))),
-		otelsql.WithDisableSkipErrMeasurement(true),
-	)
+	// 你可以用环境变量配置这些参数，连接池参数见 openDB
+	db, err = openDB(os.Getenv("DB_ADDRESS"), primaryServerAddress(), "primary")
 	if err != nil {
 		panic(err)
 	}
+	initReplicas()
 	// 建表
 	createTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -43,80 +45,320 @@ func initMysql() {
 		phone VARCHAR(32) NOT NULL,
 		email VARCHAR(128),
 		age INT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_users_phone (phone)
 	);`
 	if _, err := db.Exec(createTable); err != nil {
 		panic(err)
 	}
+	// 旧表没有 updated_at，这里补上；列已存在时忽略
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"); err != nil {
+		var myErr *mysql.MySQLError
+		if !errors.As(err, &myErr) || myErr.Number != mysqlErrDupFieldName {
+			panic(err)
+		}
+	}
+	// 旧表没有 phone 唯一索引，这里补上；索引已存在时忽略
+	if _, err := db.Exec("ALTER TABLE users ADD UNIQUE INDEX uk_users_phone (phone)"); err != nil {
+		var myErr *mysql.MySQLError
+		switch {
+		case errors.As(err, &myErr) && myErr.Number == mysqlErrDupKeyName:
+		case errors.As(err, &myErr) && myErr.Number == mysqlErrDupEntry:
+			log.Printf("users 表中存在重复的 phone，未能创建唯一索引: %v", err)
+		default:
+			panic(err)
+		}
+	}
+}
+
+// isDuplicateEntry 判断 err 是否为唯一索引冲突。
+func isDuplicateEntry(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == mysqlErrDupEntry
 }
 
 type User struct {
-	Id        int64  `json:"id"`
-	Name      string `json:"name"`
-	Gender    string `json:"gender"`
-	Phone     string `json:"phone"`
-	Email     string `json:"email"`
-	Age       int    `json:"age"`
-	CreatedAt int64  `json:"created_at"`
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Gender    string    `json:"gender"`
+	Phone     string    `json:"phone"`
+	Email     string    `json:"email"`
+	Age       int       `json:"age"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MarshalJSON 以 RFC 3339 输出时间，同时附带毫秒级 Unix 时间戳，方便不想解析时间字符串的调用方。
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	return json.Marshal(struct {
+		user
+		CreatedAtUnixMs int64 `json:"created_at_unix_ms"`
+		UpdatedAtUnixMs int64 `json:"updated_at_unix_ms"`
+	}{
+		user:            user(u),
+		CreatedAtUnixMs: u.CreatedAt.UnixMilli(),
+		UpdatedAtUnixMs: u.UpdatedAt.UnixMilli(),
+	})
+}
+
+// userColumns 是 scanUser 期望的列顺序。
+const userColumns = "id, name, gender, phone, email, age, created_at, updated_at"
+
+// rowScanner 是 *sql.Row 和 *sql.Rows 的公共接口。
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser 按 userColumns 的顺序读取一行，gender、email、age 允许为 NULL。
+func scanUser(row rowScanner) (User, error) {
+	var (
+		u      User
+		gender sql.NullString
+		email  sql.NullString
+		age    sql.NullInt64
+	)
+	if err := row.Scan(&u.Id, &u.Name, &gender, &u.Phone, &email, &age, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return User{}, err
+	}
+	u.Gender = gender.String
+	u.Email = email.String
+	u.Age = int(age.Int64)
+	return u, nil
 }
 
 func CreateUser(c *gin.Context) {
 	var user User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(400, gin.H{"error": "invalid request"})
+		writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", "request body is not a valid user JSON"), err)
+		return
+	}
+	if errs := validateUser(&user); len(errs) > 0 {
+		p := newProblem(http.StatusBadRequest, "validation-error", "one or more fields are invalid")
+		p.InvalidParams = errs
+		writeProblem(c, p, nil)
 		return
 	}
-	if user.Name == "" || user.Phone == "" {
-		c.JSON(400, gin.H{"error": "name and phone required"})
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to create user"), err)
 		return
 	}
-	res, err := db.ExecContext(c.Request.Context(), "INSERT INTO users (name, gender, phone, email, age) VALUES (?, ?, ?, ?, ?)",
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO users (name, gender, phone, email, age) VALUES (?, ?, ?, ?, ?)",
 		user.Name, user.Gender, user.Phone, user.Email, user.Age)
+	if isDuplicateEntry(err) {
+		writeDuplicatePhone(c, err)
+		return
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error": "db error"})
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to create user"), err)
 		return
 	}
-	user.Id, _ = res.LastInsertId()
-	c.JSON(200, user)
+	id, err := res.LastInsertId()
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to create user"), err)
+		return
+	}
+	// 重新读取一次，拿到数据库生成的 created_at/updated_at
+	created, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id=?", id))
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read created user"), err)
+		return
+	}
+	if err := recordUserChange(ctx, tx, auditActionCreate, actorFromRequest(c), nil, &created); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to write audit log"), err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to create user"), err)
+		return
+	}
+	userIndex.upsert(created)
+	enqueueWelcome(ctx, created)
+	c.JSON(200, created)
+}
+
+// writeDuplicatePhone 返回 phone 唯一索引冲突的 409 响应。
+func writeDuplicatePhone(c *gin.Context, err error) {
+	p := newProblem(http.StatusConflict, "duplicate-user", "a user with this phone already exists")
+	p.InvalidParams = []FieldError{{Name: "phone", Reason: "already exists"}}
+	writeProblem(c, p, err)
+}
+
+// userIdParam 解析路径中的用户 id，失败时已写出 400 响应。
+func userIdParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		p := newProblem(http.StatusBadRequest, "validation-error", "invalid user id")
+		p.InvalidParams = []FieldError{{Name: "id", Reason: "must be a positive integer"}}
+		writeProblem(c, p, err)
+		return 0, false
+	}
+	return id, true
+}
+
+// lockUser 在 tx 中读取并锁定用户，用于更新和删除前获取变更前的数据。
+func lockUser(ctx context.Context, tx *sql.Tx, id int64) (User, error) {
+	return scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id=? FOR UPDATE", id))
 }
 
 func GetUser(c *gin.Context) {
 	name := c.Query("name")
 	phone := c.Query("phone")
 	if name == "" && phone == "" {
-		c.JSON(400, gin.H{"error": "name or phone required"})
+		writeProblem(c, newProblem(http.StatusBadRequest, "missing-query", "name or phone required"), nil)
 		return
 	}
-	var user User
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
 	var row *sql.Row
 	if name != "" && phone != "" {
-		row = db.QueryRowContext(c.Request.Context(), "SELECT id, name, gender, phone, email, age, created_at FROM users WHERE name=? AND phone=? LIMIT 1", name, phone)
+		row = readDB().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE name=? AND phone=? LIMIT 1", name, phone)
 	} else if name != "" {
-		row = db.QueryRowContext(c.Request.Context(), "SELECT id, name, gender, phone, email, age, created_at FROM users WHERE name=? LIMIT 1", name)
+		row = readDB().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE name=? LIMIT 1", name)
 	} else {
-		row = db.QueryRowContext(c.Request.Context(), "SELECT id, name, gender, phone, email, age, created_at FROM users WHERE phone=? LIMIT 1", phone)
+		row = readDB().QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE phone=? LIMIT 1", phone)
+	}
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(c, newProblem(http.StatusNotFound, "user-not-found", "user not found"), err)
+		return
 	}
-	err := row.Scan(&user.Id, &user.Name, &user.Gender, &user.Phone, &user.Email, &user.Age, &user.CreatedAt)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "user not found"})
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read user"), err)
 		return
 	}
 	c.JSON(200, user)
 }
 
 func ListUsers(c *gin.Context) {
-	rows, err := db.QueryContext(c.Request.Context(), "SELECT id, name, gender, phone, email, age, created_at FROM users ORDER BY created_at DESC LIMIT 100")
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB().QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY created_at DESC LIMIT 100")
 	if err != nil {
-		c.JSON(500, gin.H{"error": "db error"})
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to list users"), err)
 		return
 	}
 	defer rows.Close()
 	users := []User{}
 	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.Id, &user.Name, &user.Gender, &user.Phone, &user.Email, &user.Age, &user.CreatedAt); err == nil {
-			users = append(users, user)
+		user, err := scanUser(rows)
+		if err != nil {
+			writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read users"), err)
+			return
 		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read users"), err)
+		return
 	}
 	c.JSON(200, users)
 }
+
+// UpdateUser 处理 PUT /users/:id，用请求体整体替换用户的业务字段。
+func UpdateUser(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+	var user User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", "request body is not a valid user JSON"), err)
+		return
+	}
+	if errs := validateUser(&user); len(errs) > 0 {
+		p := newProblem(http.StatusBadRequest, "validation-error", "one or more fields are invalid")
+		p.InvalidParams = errs
+		writeProblem(c, p, nil)
+		return
+	}
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to update user"), err)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := lockUser(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(c, newProblem(http.StatusNotFound, "user-not-found", "user not found"), err)
+		return
+	}
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read user"), err)
+		return
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE users SET name=?, gender=?, phone=?, email=?, age=? WHERE id=?",
+		user.Name, user.Gender, user.Phone, user.Email, user.Age, id)
+	if isDuplicateEntry(err) {
+		writeDuplicatePhone(c, err)
+		return
+	}
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to update user"), err)
+		return
+	}
+	after, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id=?", id))
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read updated user"), err)
+		return
+	}
+	if err := recordUserChange(ctx, tx, auditActionUpdate, actorFromRequest(c), &before, &after); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to write audit log"), err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to update user"), err)
+		return
+	}
+	userIndex.upsert(after)
+	c.JSON(200, after)
+}
+
+// DeleteUser 处理 DELETE /users/:id。
+func DeleteUser(c *gin.Context) {
+	id, ok := userIdParam(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to delete user"), err)
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := lockUser(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(c, newProblem(http.StatusNotFound, "user-not-found", "user not found"), err)
+		return
+	}
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to read user"), err)
+		return
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id=?", id); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to delete user"), err)
+		return
+	}
+	if err := recordUserChange(ctx, tx, auditActionDelete, actorFromRequest(c), &before, nil); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to write audit log"), err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to delete user"), err)
+		return
+	}
+	userIndex.remove(id)
+	c.Status(http.StatusNoContent)
+}
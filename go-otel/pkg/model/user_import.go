@@ -0,0 +1,476 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	defaultImportBatchSize = 500
+	maxImportBatchSize     = 5000
+	// 返回给调用方的行级错误上限，避免响应体过大
+	maxImportRowErrors = 1000
+)
+
+// userCSVColumns 是导入导出 CSV 使用的列，导入时 id、created_at 和 updated_at 会被忽略。
+var userCSVColumns = []string{"id", "name", "gender", "phone", "email", "age", "created_at", "updated_at"}
+
+// CustomMethods 按 "/users:import" 这类自定义方法名分发请求，
+// 路由需注册为 "/users:method"。路由参数会匹配 "/users" 之后的任意内容，
+// 不以冒号开头的路径（如 "/usersexport"）返回 404。
+func CustomMethods(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		param := c.Param("method")
+		method, found := strings.CutPrefix(param, ":")
+		h, ok := handlers[method]
+		if !found || !ok {
+			writeProblem(c, newProblem(http.StatusNotFound, "not-found", "unknown method: "+param), nil)
+			return
+		}
+		h(c)
+	}
+}
+
+// ImportRowError 描述导入时某一行的失败原因。
+type ImportRowError struct {
+	Line          int          `json:"line"`
+	Detail        string       `json:"detail"`
+	InvalidParams []FieldError `json:"invalid-params,omitempty"`
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Detail)
+}
+
+// ImportResult 是 POST /users:import 的响应体。
+type ImportResult struct {
+	Format   string           `json:"format"`
+	DryRun   bool             `json:"dry_run"`
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Batches  int              `json:"batches"`
+	Errors   []ImportRowError `json:"errors"`
+	// Truncated 为 true 表示 Errors 只包含前 maxImportRowErrors 条
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (r *ImportResult) addError(e ImportRowError) {
+	r.Failed++
+	if len(r.Errors) >= maxImportRowErrors {
+		r.Truncated = true
+		return
+	}
+	r.Errors = append(r.Errors, e)
+}
+
+// importRow 是解析出来待写入的一行。
+type importRow struct {
+	line int
+	user User
+}
+
+// userDecoder 从请求体中逐行解码用户。
+// 返回 io.EOF 表示结束；返回 *ImportRowError 表示该行无法解析，可以继续读取下一行。
+type userDecoder interface {
+	Next() (importRow, error)
+}
+
+type csvUserDecoder struct {
+	r      *csv.Reader
+	header map[string]int
+}
+
+func newCSVUserDecoder(r io.Reader) (*csvUserDecoder, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	head, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	header := make(map[string]int, len(head))
+	for i, h := range head {
+		header[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"name", "phone"} {
+		if _, ok := header[required]; !ok {
+			return nil, fmt.Errorf("csv header missing column %q", required)
+		}
+	}
+	return &csvUserDecoder{r: cr, header: header}, nil
+}
+
+func (d *csvUserDecoder) field(rec []string, name string) string {
+	if i, ok := d.header[name]; ok && i < len(rec) {
+		return rec[i]
+	}
+	return ""
+}
+
+func (d *csvUserDecoder) Next() (importRow, error) {
+	rec, err := d.r.Read()
+	if err == io.EOF {
+		return importRow{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return importRow{}, &ImportRowError{Line: parseErr.Line, Detail: parseErr.Err.Error()}
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+	line, _ := d.r.FieldPos(0)
+	u := User{
+		Name:   d.field(rec, "name"),
+		Gender: d.field(rec, "gender"),
+		Phone:  d.field(rec, "phone"),
+		Email:  d.field(rec, "email"),
+	}
+	if age := strings.TrimSpace(d.field(rec, "age")); age != "" {
+		if u.Age, err = strconv.Atoi(age); err != nil {
+			return importRow{}, &ImportRowError{
+				Line:          line,
+				Detail:        "invalid row",
+				InvalidParams: []FieldError{{Name: "age", Reason: "must be an integer"}},
+			}
+		}
+	}
+	return importRow{line: line, user: u}, nil
+}
+
+type ndjsonUserDecoder struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONUserDecoder(r io.Reader) *ndjsonUserDecoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ndjsonUserDecoder{s: s}
+}
+
+func (d *ndjsonUserDecoder) Next() (importRow, error) {
+	for d.s.Scan() {
+		d.line++
+		b := d.s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var u User
+		if err := json.Unmarshal(b, &u); err != nil {
+			return importRow{}, &ImportRowError{Line: d.line, Detail: "invalid json: " + err.Error()}
+		}
+		return importRow{line: d.line, user: u}, nil
+	}
+	if err := d.s.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
+
+// requestFormat 根据 format 参数或 Content-Type/Accept 头决定使用的格式。
+func requestFormat(c *gin.Context, header string) string {
+	if f := strings.ToLower(c.Query("format")); f != "" {
+		return f
+	}
+	v := strings.ToLower(c.GetHeader(header))
+	switch {
+	case strings.Contains(v, "csv"):
+		return formatCSV
+	case strings.Contains(v, "ndjson"), strings.Contains(v, "jsonl"):
+		return formatNDJSON
+	}
+	return formatNDJSON
+}
+
+// ImportUsers 处理 POST /users:import，流式读取 CSV 或 NDJSON 请求体并分批写入。
+// 每批在一个事务中完成，单行失败不影响同批次其它行；dry_run=true 时只校验，所有事务都会回滚。
+func ImportUsers(c *gin.Context) {
+	ctx, span := otel.Tracer("user").Start(c.Request.Context(), "users.import")
+	defer span.End()
+
+	format := requestFormat(c, "Content-Type")
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	batchSize := defaultImportBatchSize
+	if v := c.Query("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxImportBatchSize {
+			p := newProblem(http.StatusBadRequest, "validation-error", "invalid query parameter")
+			p.InvalidParams = []FieldError{{Name: "batch_size", Reason: fmt.Sprintf("must be between 1 and %d", maxImportBatchSize)}}
+			writeProblem(c, p, err)
+			return
+		}
+		batchSize = n
+	}
+	span.SetAttributes(
+		attribute.String("import.format", format),
+		attribute.Bool("import.dry_run", dryRun),
+		attribute.Int("import.batch_size", batchSize),
+	)
+
+	var dec userDecoder
+	switch format {
+	case formatCSV:
+		d, err := newCSVUserDecoder(c.Request.Body)
+		if err != nil {
+			writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", err.Error()), err)
+			return
+		}
+		dec = d
+	case formatNDJSON:
+		dec = newNDJSONUserDecoder(c.Request.Body)
+	default:
+		p := newProblem(http.StatusBadRequest, "validation-error", "unsupported format")
+		p.InvalidParams = []FieldError{{Name: "format", Reason: "must be csv or ndjson"}}
+		writeProblem(c, p, nil)
+		return
+	}
+
+	result := &ImportResult{Format: format, DryRun: dryRun, Errors: []ImportRowError{}}
+	batch := make([]importRow, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := importBatch(ctx, batch, result, actorFromRequest(c), dryRun)
+		batch = batch[:0]
+		span.AddEvent("import.progress", gotrace.WithAttributes(
+			attribute.Int("import.total", result.Total),
+			attribute.Int("import.imported", result.Imported),
+			attribute.Int("import.failed", result.Failed),
+		))
+		return err
+	}
+
+	for {
+		row, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *ImportRowError
+		if errors.As(err, &rowErr) {
+			result.Total++
+			result.addError(*rowErr)
+			continue
+		}
+		if err != nil {
+			// 请求体读取失败，已提交的批次保留
+			writeProblem(c, newProblem(http.StatusBadRequest, "invalid-body", "failed to read request body: "+err.Error()), err)
+			return
+		}
+		result.Total++
+		if errs := validateUser(&row.user); len(errs) > 0 {
+			result.addError(ImportRowError{Line: row.line, Detail: "invalid row", InvalidParams: errs})
+			continue
+		}
+		batch = append(batch, row)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to import users"), err)
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to import users"), err)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("import.total", result.Total),
+		attribute.Int("import.imported", result.Imported),
+		attribute.Int("import.failed", result.Failed),
+		attribute.Int("import.batches", result.Batches),
+	)
+	c.JSON(http.StatusOK, result)
+}
+
+// importBatch 在一个事务中写入一批用户及其审计记录，行级错误记入 result。
+func importBatch(ctx context.Context, batch []importRow, result *ImportResult, actor string, dryRun bool) (err error) {
+	ctx, span := otel.Tracer("user").Start(ctx, "users.import.batch")
+	defer span.End()
+	result.Batches++
+	span.SetAttributes(
+		attribute.Int("import.batch.index", result.Batches),
+		attribute.Int("import.batch.rows", len(batch)),
+		attribute.Int("import.batch.first_line", batch[0].line),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO users (name, gender, phone, email, age) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	// 与 CreateUser 一样重新读取插入的行，审计和 outbox 事件中带上数据库生成的 created_at/updated_at
+	readStmt, err := tx.PrepareContext(ctx, "SELECT "+userColumns+" FROM users WHERE id=?")
+	if err != nil {
+		return err
+	}
+	defer readStmt.Close()
+
+	var inserted []User
+	for _, row := range batch {
+		u := row.user
+		res, err := stmt.ExecContext(ctx, u.Name, u.Gender, u.Phone, u.Email, u.Age)
+		switch {
+		case err == nil:
+			id, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			if u, err = scanUser(readStmt.QueryRowContext(ctx, id)); err != nil {
+				return err
+			}
+			if err := recordUserChange(ctx, tx, auditActionCreate, actor, nil, &u); err != nil {
+				return err
+			}
+			inserted = append(inserted, u)
+		case isDuplicateEntry(err):
+			// InnoDB 只回滚出错的这条语句，事务仍然可用
+			result.addError(ImportRowError{
+				Line:          row.line,
+				Detail:        "duplicate user",
+				InvalidParams: []FieldError{{Name: "phone", Reason: "already exists"}},
+			})
+		default:
+			return err
+		}
+	}
+	span.SetAttributes(attribute.Int("import.batch.imported", len(inserted)))
+
+	if dryRun {
+		result.Imported += len(inserted)
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	result.Imported += len(inserted)
+	for _, u := range inserted {
+		userIndex.upsert(u)
+	}
+	return nil
+}
+
+// ExportUsers 处理 GET /users:export，以 CSV 或 NDJSON 流式输出全部用户。
+func ExportUsers(c *gin.Context) {
+	ctx, span := otel.Tracer("user").Start(c.Request.Context(), "users.export")
+	defer span.End()
+
+	format := requestFormat(c, "Accept")
+	span.SetAttributes(attribute.String("export.format", format))
+	if format != formatCSV && format != formatNDJSON {
+		p := newProblem(http.StatusBadRequest, "validation-error", "unsupported format")
+		p.InvalidParams = []FieldError{{Name: "format", Reason: "must be csv or ndjson"}}
+		writeProblem(c, p, nil)
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY id")
+	if err != nil {
+		writeProblem(c, newProblem(http.StatusInternalServerError, "db-error", "failed to export users"), err)
+		return
+	}
+	defer rows.Close()
+
+	if format == formatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="users.csv"`)
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="users.ndjson"`)
+	}
+	c.Status(http.StatusOK)
+
+	n, err := writeUsers(c.Writer, rows, format)
+	span.SetAttributes(attribute.Int("export.rows", n))
+	if err != nil {
+		// 响应头已经发出，只能记录错误并中断输出
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "export aborted")
+		c.Abort()
+	}
+}
+
+// writeUsers 把查询结果按指定格式写出，每 100 行 flush 一次。
+func writeUsers(w gin.ResponseWriter, rows *sql.Rows, format string) (int, error) {
+	var (
+		cw  *csv.Writer
+		enc *json.Encoder
+	)
+	if format == formatCSV {
+		cw = csv.NewWriter(w)
+		if err := cw.Write(userCSVColumns); err != nil {
+			return 0, err
+		}
+	} else {
+		enc = json.NewEncoder(w)
+	}
+
+	n := 0
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return n, err
+		}
+		if cw != nil {
+			err := cw.Write([]string{
+				strconv.FormatInt(user.Id, 10), user.Name, user.Gender, user.Phone, user.Email,
+				strconv.Itoa(user.Age), user.CreatedAt.Format(time.RFC3339), user.UpdatedAt.Format(time.RFC3339),
+			})
+			if err != nil {
+				return n, err
+			}
+		} else if err := enc.Encode(user); err != nil {
+			return n, err
+		}
+		n++
+		if n%100 == 0 {
+			if cw != nil {
+				cw.Flush()
+			}
+			w.Flush()
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return n, err
+		}
+	}
+	w.Flush()
+	return n, rows.Err()
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCustomMethods(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/users:method", CustomMethods(map[string]gin.HandlerFunc{
+		"export": func(c *gin.Context) { c.Status(http.StatusNoContent) },
+	}))
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/users:export", http.StatusNoContent},
+		{"/users:import", http.StatusNotFound},
+		{"/usersexport", http.StatusNotFound},
+		{"/users-export", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, w.Code, tc.want)
+		}
+	}
+}
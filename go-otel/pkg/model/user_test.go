@@ -0,0 +1,188 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 测试用的 users 表。MySQL 上使用临时表，只对当前连接可见，不会影响库里已有的 users 表
+const (
+	sqliteUsersTable = `CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(64) NOT NULL,
+		gender VARCHAR(8),
+		phone VARCHAR(32) NOT NULL UNIQUE,
+		email VARCHAR(128),
+		age INT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	mysqlUsersTable = `CREATE TEMPORARY TABLE users (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		gender VARCHAR(8),
+		phone VARCHAR(32) NOT NULL,
+		email VARCHAR(128),
+		age INT,
+		created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_users_phone (phone)
+	)`
+)
+
+// forEachEngine 在进程内的 SQLite 上运行 fn（需要 cgo）；设置了 MYSQL_TEST_DSN 时再在 MySQL 上运行一次，
+// DSN 会强制带上 parseTime=true，与 openDB 一致。
+func forEachEngine(t *testing.T, fn func(t *testing.T)) {
+	t.Run("sqlite", func(t *testing.T) {
+		if sqliteDriver == "" {
+			t.Skip("SQLite driver requires cgo")
+		}
+		useTestDB(t, openTestDB(t, sqliteDriver, ":memory:"), sqliteUsersTable)
+		fn(t)
+	})
+	t.Run("mysql", func(t *testing.T) {
+		dsn := os.Getenv("MYSQL_TEST_DSN")
+		if dsn == "" {
+			t.Skip("MYSQL_TEST_DSN not set")
+		}
+		conf, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("parse MYSQL_TEST_DSN: %v", err)
+		}
+		conf.ParseTime = true
+		useTestDB(t, openTestDB(t, "mysql", conf.FormatDSN()), mysqlUsersTable)
+		fn(t)
+	})
+}
+
+func openTestDB(t *testing.T, driver, dsn string) *sql.DB {
+	t.Helper()
+	testDB, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("open %s: %v", driver, err)
+	}
+	// 内存数据库和临时表都只属于一个连接
+	testDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { testDB.Close() })
+	return testDB
+}
+
+// useTestDB 建表并把包级的 db 替换为 testDB，测试结束后恢复。
+func useTestDB(t *testing.T, testDB *sql.DB, ddl string) {
+	t.Helper()
+	if _, err := testDB.Exec(ddl); err != nil {
+		t.Fatalf("create users table: %v", err)
+	}
+	prevDB, prevReplicas := db, replicas
+	db, replicas = testDB, nil
+	t.Cleanup(func() { db, replicas = prevDB, prevReplicas })
+}
+
+func insertUser(t *testing.T, name, phone string, createdAt, updatedAt any) {
+	t.Helper()
+	if _, err := db.Exec("INSERT INTO users (name, gender, phone, email, age, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		name, "male", phone, name+"@example.com", 30, createdAt, updatedAt); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+}
+
+func serve(handler gin.HandlerFunc, target string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	handler(c)
+	return w
+}
+
+func assertTimes(t *testing.T, u User, createdAt, updatedAt time.Time) {
+	t.Helper()
+	if !u.CreatedAt.Equal(createdAt) {
+		t.Errorf("user %s: created_at = %v, want %v", u.Phone, u.CreatedAt, createdAt)
+	}
+	if !u.UpdatedAt.Equal(updatedAt) {
+		t.Errorf("user %s: updated_at = %v, want %v", u.Phone, u.UpdatedAt, updatedAt)
+	}
+}
+
+func TestGetUserTimestamps(t *testing.T) {
+	forEachEngine(t, func(t *testing.T) {
+		createdAt := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+		updatedAt := time.Date(2024, 5, 20, 17, 45, 10, 0, time.UTC)
+		insertUser(t, "alice", "13800000001", createdAt, updatedAt)
+
+		w := serve(GetUser, "/user?phone=13800000001")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+		}
+		var u User
+		if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+			t.Fatalf("decode user: %v", err)
+		}
+		if u.Name != "alice" || u.Id == 0 {
+			t.Errorf("user = %+v", u)
+		}
+		assertTimes(t, u, createdAt, updatedAt)
+	})
+}
+
+func TestListUsersTimestamps(t *testing.T) {
+	forEachEngine(t, func(t *testing.T) {
+		older := time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)
+		newer := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+		insertUser(t, "bob", "13800000002", older, newer)
+		insertUser(t, "carol", "13800000003", newer, newer)
+
+		w := serve(ListUsers, "/users")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+		}
+		var users []User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("decode users: %v", err)
+		}
+		if len(users) != 2 {
+			t.Fatalf("got %d users, want 2", len(users))
+		}
+		// 按 created_at 倒序
+		if users[0].Name != "carol" || users[1].Name != "bob" {
+			t.Errorf("order = %s, %s", users[0].Name, users[1].Name)
+		}
+		assertTimes(t, users[0], newer, newer)
+		assertTimes(t, users[1], older, newer)
+	})
+}
+
+// 无法读取的行必须让请求失败，而不是被悄悄跳过
+func TestListUsersScanError(t *testing.T) {
+	forEachEngine(t, func(t *testing.T) {
+		now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		insertUser(t, "dave", "13800000004", now, now)
+		insertUser(t, "erin", "13800000005", nil, now)
+
+		w := serve(ListUsers, "/users")
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want 500, body = %s", w.Code, w.Body)
+		}
+		var p Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode problem: %v", err)
+		}
+		if p.Detail != "failed to read users" {
+			t.Errorf("detail = %q", p.Detail)
+		}
+
+		w = serve(GetUser, "/user?phone=13800000005")
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("GetUser status = %d, want 500, body = %s", w.Code, w.Body)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLen  = 64
+	maxEmailLen = 128
+	minAge      = 0
+	maxAge      = 150
+)
+
+var (
+	// e164Pattern 匹配 E.164 格式的电话号码，如 +8613800138000
+	e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+	validGenders = map[string]bool{
+		"male":    true,
+		"female":  true,
+		"other":   true,
+		"unknown": true,
+	}
+)
+
+// FieldError 描述一个字段的校验失败原因，对应 RFC 7807 中的 invalid-params。
+type FieldError struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// validateUser 校验创建用户的请求，返回所有不合法的字段。
+func validateUser(u *User) []FieldError {
+	var errs []FieldError
+	add := func(name, reason string) {
+		errs = append(errs, FieldError{Name: name, Reason: reason})
+	}
+
+	u.Name = strings.TrimSpace(u.Name)
+	u.Phone = strings.TrimSpace(u.Phone)
+	u.Email = strings.TrimSpace(u.Email)
+	u.Gender = strings.ToLower(strings.TrimSpace(u.Gender))
+
+	switch {
+	case u.Name == "":
+		add("name", "is required")
+	case utf8.RuneCountInString(u.Name) > maxNameLen:
+		add("name", fmt.Sprintf("must be at most %d characters", maxNameLen))
+	}
+
+	switch {
+	case u.Phone == "":
+		add("phone", "is required")
+	case !e164Pattern.MatchString(u.Phone):
+		add("phone", "must be in E.164 format, e.g. +8613800138000")
+	}
+
+	if u.Email != "" {
+		if len(u.Email) > maxEmailLen {
+			add("email", fmt.Sprintf("must be at most %d characters", maxEmailLen))
+		} else if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+			add("email", "must be a valid email address")
+		}
+	}
+
+	if u.Age < minAge || u.Age > maxAge {
+		add("age", fmt.Sprintf("must be between %d and %d", minAge, maxAge))
+	}
+
+	if u.Gender != "" && !validGenders[u.Gender] {
+		add("gender", "must be one of male, female, other, unknown")
+	}
+
+	return errs
+}
@@ -0,0 +1,131 @@
+// Package random 提供按请求注入、可指定种子的随机数来源，
+// 使用同一个种子重放请求时，掷骰结果和注入的故障都会完全一致。
+package random
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+// SeedHeader 是指定或回传随机种子的 HTTP 头。
+const SeedHeader = "X-Random-Seed"
+
+// SeedAttribute 是记录随机种子的 span 属性。
+const SeedAttribute = "random.seed"
+
+type ctxKey struct{}
+
+// Rand 是并发安全的随机数来源，同一个种子产生相同的序列。
+type Rand struct {
+	mu   sync.Mutex
+	r    *rand.Rand
+	seed int64
+}
+
+// New 返回以 seed 为种子的 Rand。
+func New(seed int64) *Rand {
+	return &Rand{r: rand.New(rand.NewSource(seed)), seed: seed}
+}
+
+// Seed 返回创建时使用的种子。
+func (r *Rand) Seed() int64 { return r.seed }
+
+// Intn 返回 [0, n) 之间的随机整数。
+func (r *Rand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// Float64 返回 [0.0, 1.0) 之间的随机浮点数。
+func (r *Rand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// NormFloat64 返回标准正态分布的随机数。
+func (r *Rand) NormFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.NormFloat64()
+}
+
+// ExpFloat64 返回均值为 1 的指数分布随机数。
+func (r *Rand) ExpFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.ExpFloat64()
+}
+
+// NewSeed 生成一个新的随机种子。
+func NewSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]) >> 1)
+}
+
+// NewContext 返回携带 r 的 context。
+func NewContext(ctx context.Context, r *Rand) context.Context {
+	return context.WithValue(ctx, ctxKey{}, r)
+}
+
+// FromContext 返回 ctx 中的 Rand；没有时新建一个随机种子的 Rand，并把种子记录到当前 span 上，
+// 保证任何一次随机行为都可以按种子重放。
+func FromContext(ctx context.Context) *Rand {
+	if r, ok := ctx.Value(ctxKey{}).(*Rand); ok {
+		return r
+	}
+	r := New(NewSeed())
+	gotrace.SpanFromContext(ctx).SetAttributes(attribute.Int64(SeedAttribute, r.Seed()))
+	return r
+}
+
+// configuredSeed 返回 RANDOM_SEED 配置的种子，未配置时 ok 为 false。
+// 配置后所有请求都使用同一个种子，适合整体重放一段演示流量。
+func configuredSeed() (seed int64, ok bool) {
+	v := os.Getenv("RANDOM_SEED")
+	if v == "" {
+		return 0, false
+	}
+	seed, err := strconv.ParseInt(v, 10, 64)
+	return seed, err == nil
+}
+
+// Middleware 为每个请求注入 Rand：种子优先取 X-Random-Seed 请求头，其次是 RANDOM_SEED 配置，
+// 都没有时随机生成。种子会记录为 span 属性并通过响应头返回，便于重放。
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		seed, ok := configuredSeed()
+		if v := c.GetHeader(SeedHeader); v != "" {
+			s, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": SeedHeader + " must be a 64-bit integer"})
+				return
+			}
+			seed, ok = s, true
+		}
+		if !ok {
+			seed = NewSeed()
+		}
+
+		ctx := c.Request.Context()
+		gotrace.SpanFromContext(ctx).SetAttributes(attribute.Int64(SeedAttribute, seed))
+		c.Request = c.Request.WithContext(NewContext(ctx, New(seed)))
+		c.Header(SeedHeader, strconv.FormatInt(seed, 10))
+		c.Next()
+	}
+}
@@ -0,0 +1,40 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
+)
+
+// faultHook 在每条命令执行前注入 "redis.<命令名>" 的故障，管道为 "redis.pipeline"。
+type faultHook struct{}
+
+func (faultHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (faultHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := fault.Inject(ctx, fault.TargetRedis+"."+cmd.Name()); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (faultHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := fault.Inject(ctx, fault.TargetRedis+".pipeline"); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+var _ redis.Hook = faultHook{}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = time.Second
+	// 连续失败达到该次数才判定为不健康，避免偶发超时导致 readiness 抖动
+	defaultHealthFailureThreshold = 3
+)
+
+// Health 是最近一次 PING 探测的结果。
+type Health struct {
+	Healthy             bool      `json:"healthy"`
+	Error               string    `json:"error,omitempty"`
+	LatencyMs           float64   `json:"latency_ms"`
+	CheckedAt           time.Time `json:"checked_at"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+var health atomic.Pointer[Health]
+
+// CurrentHealth 返回最近一次探测的结果，尚未探测过时视为不健康。
+func CurrentHealth() Health {
+	if h := health.Load(); h != nil {
+		return *h
+	}
+	return Health{Error: "not checked yet"}
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// StartHealthProbe 每隔 REDIS_HEALTH_INTERVAL（默认 10s）PING 一次，
+// 连续失败 REDIS_HEALTH_FAILURE_THRESHOLD（默认 3）次后判定为不健康，一次成功即恢复。
+func StartHealthProbe(ctx context.Context) {
+	interval := envDuration("REDIS_HEALTH_INTERVAL", defaultHealthInterval)
+	timeout := envDuration("REDIS_HEALTH_TIMEOUT", defaultHealthTimeout)
+	threshold, err := strconv.Atoi(os.Getenv("REDIS_HEALTH_FAILURE_THRESHOLD"))
+	if err != nil || threshold < 1 {
+		threshold = defaultHealthFailureThreshold
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			probe(ctx, timeout, threshold)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func probe(ctx context.Context, timeout time.Duration, threshold int) {
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := Rdb.Ping(pctx).Err()
+	h := &Health{
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+		CheckedAt: time.Now().UTC(),
+	}
+	prev := CurrentHealth()
+	if err == nil {
+		h.Healthy = true
+	} else {
+		h.Error = err.Error()
+		h.ConsecutiveFailures = prev.ConsecutiveFailures + 1
+		// 第一次探测就失败时直接判定为不健康
+		h.Healthy = health.Load() != nil && prev.Healthy && h.ConsecutiveFailures < threshold
+	}
+	if prev.Healthy != h.Healthy {
+		log.Printf("redis health changed: healthy=%t error=%q", h.Healthy, h.Error)
+	}
+	health.Store(h)
+}
@@ -0,0 +1,268 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+const lockKeyPrefix = "go-demo:lock:"
+
+var (
+	// ErrLockNotAcquired 表示锁被其他持有者占用。
+	ErrLockNotAcquired = errors.New("redis: lock not acquired")
+	// ErrLockLost 表示续期时发现锁已过期或被他人持有。
+	ErrLockLost = errors.New("redis: lock lost")
+)
+
+// 加锁成功时原子地递增 fencing 计数器并返回新值。锁和计数器使用相同的 hash tag，Cluster 模式下位于同一个 slot。
+var lockAcquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// lockAcquireNoFenceScript 用于多节点 Redlock，只加锁、不发放 fencing token。
+var lockAcquireNoFenceScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+var lockRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var lockReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Locker 在一组相互独立的 Redis 主节点上实现 Redlock：在多数节点上加锁成功且耗时小于 TTL 才算持有。
+// 只有一个节点时退化为单实例锁。
+//
+// fencing token 只在单节点时发放：各节点的计数器彼此独立，新的多数派可能全部落在计数器较小的节点上，
+// 取任何节点或多数派的值都无法保证单调。
+type Locker struct {
+	clients []redis.UniversalClient
+}
+
+// NewLocker 返回使用 clients 的 Locker，不传时使用 Rdb。
+func NewLocker(clients ...redis.UniversalClient) *Locker {
+	if len(clients) == 0 {
+		clients = []redis.UniversalClient{Rdb}
+	}
+	return &Locker{clients: clients}
+}
+
+func (l *Locker) quorum() int {
+	return len(l.clients)/2 + 1
+}
+
+// Lock 是一把已持有的锁。
+type Lock struct {
+	locker   *Locker
+	name     string
+	key      string
+	fenceKey string
+	value    string
+	ttl      time.Duration
+	// Token 是单调递增的 fencing token，下游写入时带上它可以拒绝过期持有者的请求。
+	// 只有单节点 Locker 会发放，多节点时为 0
+	Token int64
+}
+
+func lockKeys(name string) (key, fenceKey string) {
+	key = lockKeyPrefix + "{" + name + "}"
+	return key, key + ":fence"
+}
+
+// clockDrift 是 Redlock 为节点间时钟漂移预留的时间。
+func clockDrift(ttl time.Duration) time.Duration {
+	return ttl/100 + 2*time.Millisecond
+}
+
+// Obtain 尝试一次获取名为 name 的锁，被占用时返回 ErrLockNotAcquired；
+// 能应答的节点不足多数时返回各节点的错误。
+func (l *Locker) Obtain(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	ctx, span := otel.Tracer("redis").Start(ctx, "redis.lock.acquire", gotrace.WithAttributes(
+		attribute.String("lock.name", name),
+		attribute.Int64("lock.ttl_ms", ttl.Milliseconds()),
+	))
+	defer span.End()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key, fenceKey := lockKeys(name)
+	lk := &Lock{locker: l, name: name, key: key, fenceKey: fenceKey, value: hex.EncodeToString(b), ttl: ttl}
+
+	fenced := len(l.clients) == 1
+	script := lockAcquireNoFenceScript
+	if fenced {
+		script = lockAcquireScript
+	}
+
+	start := time.Now()
+	acquired := 0
+	var nodeErrs []error
+	for _, c := range l.clients {
+		res, err := script.Run(ctx, c, []string{key, fenceKey}, lk.value, ttl.Milliseconds()).Int64()
+		if err != nil {
+			span.RecordError(err)
+			nodeErrs = append(nodeErrs, err)
+			continue
+		}
+		if res > 0 {
+			acquired++
+			if fenced {
+				lk.Token = res
+			}
+		}
+	}
+
+	validity := ttl - time.Since(start) - clockDrift(ttl)
+	span.SetAttributes(attribute.Int("lock.nodes_acquired", acquired))
+	if acquired < l.quorum() || validity <= 0 {
+		// 释放在少数节点上拿到的锁，以免它们阻塞其他竞争者直到过期
+		lk.releaseAll(context.WithoutCancel(ctx))
+		span.SetAttributes(attribute.Bool("lock.acquired", false))
+		// 应答的节点不足多数时无法判断锁是否被占用，返回节点错误而不是 ErrLockNotAcquired
+		if len(l.clients)-len(nodeErrs) < l.quorum() {
+			err := fmt.Errorf("redis: lock %s: %w", name, errors.Join(nodeErrs...))
+			span.SetStatus(codes.Error, "lock nodes unavailable")
+			return nil, err
+		}
+		return nil, ErrLockNotAcquired
+	}
+	span.SetAttributes(attribute.Bool("lock.acquired", true))
+	if fenced {
+		span.SetAttributes(attribute.Int64("lock.fencing_token", lk.Token))
+	}
+	return lk, nil
+}
+
+// Refresh 把锁的过期时间重置为 TTL，锁已丢失时返回 ErrLockLost。
+func (lk *Lock) Refresh(ctx context.Context) error {
+	ctx, span := otel.Tracer("redis").Start(ctx, "redis.lock.renew", gotrace.WithAttributes(
+		attribute.String("lock.name", lk.name),
+		attribute.Int64("lock.fencing_token", lk.Token),
+	))
+	defer span.End()
+
+	start := time.Now()
+	renewed := 0
+	for _, c := range lk.locker.clients {
+		ok, err := lockRenewScript.Run(ctx, c, []string{lk.key}, lk.value, lk.ttl.Milliseconds()).Int64()
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		if ok == 1 {
+			renewed++
+		}
+	}
+	span.SetAttributes(attribute.Int("lock.nodes_renewed", renewed))
+	if renewed < lk.locker.quorum() || time.Since(start)+clockDrift(lk.ttl) >= lk.ttl {
+		span.SetStatus(codes.Error, ErrLockLost.Error())
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release 释放锁，只会删除自己持有的锁。
+func (lk *Lock) Release(ctx context.Context) error {
+	ctx, span := otel.Tracer("redis").Start(ctx, "redis.lock.release", gotrace.WithAttributes(
+		attribute.String("lock.name", lk.name),
+		attribute.Int64("lock.fencing_token", lk.Token),
+	))
+	defer span.End()
+	if err := lk.releaseAll(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+func (lk *Lock) releaseAll(ctx context.Context) error {
+	var errs []error
+	for _, c := range lk.locker.clients {
+		if err := lockReleaseScript.Run(ctx, c, []string{lk.key}, lk.value).Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// KeepAlive 每隔 TTL/3 续期一次，返回的 context 在 ctx 结束、锁丢失（cause 为 ErrLockLost）
+// 或调用 stop 时取消。
+func (lk *Lock) KeepAlive(ctx context.Context) (leaseCtx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		ticker := time.NewTicker(lk.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rctx, rcancel := context.WithTimeout(ctx, lk.ttl/3)
+				err := lk.Refresh(rctx)
+				rcancel()
+				if err != nil {
+					cancel(ErrLockLost)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// RunAsLeader 参与名为 name 的 leader 选举，阻塞直到 ctx 结束：获得租约后调用 run，
+// 租约续期失败时取消传给 run 的 context；run 返回后释放租约并重新参选。
+// 租约时长为 ttl，未当选时每隔 ttl/2 重试一次。
+func RunAsLeader(ctx context.Context, name string, ttl time.Duration, run func(ctx context.Context, token int64)) {
+	locker := NewLocker()
+	for {
+		lk, err := locker.Obtain(ctx, name, ttl)
+		if err == nil {
+			log.Printf("leader election %s: became leader, fencing token %d", name, lk.Token)
+			leaderCtx, stop := lk.KeepAlive(ctx)
+			run(leaderCtx, lk.Token)
+			if errors.Is(context.Cause(leaderCtx), ErrLockLost) {
+				log.Printf("leader election %s: lease lost", name)
+			}
+			stop()
+			rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+			_ = lk.Release(rctx)
+			cancel()
+		} else if !errors.Is(err, ErrLockNotAcquired) {
+			log.Printf("leader election %s: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ttl / 2):
+		}
+	}
+}
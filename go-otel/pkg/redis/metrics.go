@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "github.com/flashcatcloud/Demo/go-otel/pkg/redis"
+
+// scenarioDuration 记录每次执行场景的耗时，Init 之前为 nil。
+var scenarioDuration metric.Float64Histogram
+
+// registerMetrics 通过 MeterProvider 导出连接池统计和健康探测结果，
+// 补充 redisotel.InstrumentMetrics 提供的命令耗时和连接数指标。
+// Cluster 模式下连接池统计是所有节点的累加值。
+func registerMetrics() error {
+	meter := otel.Meter(meterName)
+	attrs := metric.WithAttributes(attribute.String("redis.mode", mode))
+
+	hits, err := meter.Int64ObservableCounter("redis.pool.hits",
+		metric.WithDescription("The number of times a free connection was found in the pool"))
+	if err != nil {
+		return err
+	}
+	misses, err := meter.Int64ObservableCounter("redis.pool.misses",
+		metric.WithDescription("The number of times a free connection was not found in the pool"))
+	if err != nil {
+		return err
+	}
+	timeouts, err := meter.Int64ObservableCounter("redis.pool.timeouts",
+		metric.WithDescription("The number of times waiting for a pool connection timed out"))
+	if err != nil {
+		return err
+	}
+	stale, err := meter.Int64ObservableCounter("redis.pool.stale_connections",
+		metric.WithDescription("The number of stale connections removed from the pool"))
+	if err != nil {
+		return err
+	}
+	conns, err := meter.Int64ObservableUpDownCounter("redis.pool.connections",
+		metric.WithDescription("The number of connections in the pool by state"))
+	if err != nil {
+		return err
+	}
+	up, err := meter.Int64ObservableGauge("redis.up",
+		metric.WithDescription("Whether the periodic PING health probe considers Redis healthy (1) or not (0)"))
+	if err != nil {
+		return err
+	}
+	pingLatency, err := meter.Float64ObservableGauge("redis.health.ping.duration",
+		metric.WithDescription("The latency of the last PING health probe"),
+		metric.WithUnit("ms"))
+	if err != nil {
+		return err
+	}
+
+	scenarioDuration, err = meter.Float64Histogram("redis.scenario.duration",
+		metric.WithDescription("The duration of running a DoSomething scenario"),
+		metric.WithUnit("ms"))
+	if err != nil {
+		return err
+	}
+
+	idleAttrs := metric.WithAttributes(attribute.String("redis.mode", mode), attribute.String("state", "idle"))
+	usedAttrs := metric.WithAttributes(attribute.String("redis.mode", mode), attribute.String("state", "used"))
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := Rdb.PoolStats()
+		o.ObserveInt64(hits, int64(stats.Hits), attrs)
+		o.ObserveInt64(misses, int64(stats.Misses), attrs)
+		o.ObserveInt64(timeouts, int64(stats.Timeouts), attrs)
+		o.ObserveInt64(stale, int64(stats.StaleConns), attrs)
+		o.ObserveInt64(conns, int64(stats.IdleConns), idleAttrs)
+		o.ObserveInt64(conns, int64(stats.TotalConns-stats.IdleConns), usedAttrs)
+
+		h := CurrentHealth()
+		var v int64
+		if h.Healthy {
+			v = 1
+		}
+		o.ObserveInt64(up, v, attrs)
+		if !h.CheckedAt.IsZero() {
+			o.ObserveFloat64(pingLatency, h.LatencyMs, attrs)
+		}
+		return nil
+	}, hits, misses, timeouts, stale, conns, up, pingLatency)
+	return err
+}
@@ -0,0 +1,448 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	gotrace "go.opentelemetry.io/otel/trace"
+)
+
+const queueKeyPrefix = "go-demo:jobs:"
+
+// 消息中的保留字段，其余字段是 trace context（traceparent、tracestate、baggage）
+const (
+	fieldType       = "type"
+	fieldPayload    = "payload"
+	fieldAttempt    = "attempt"
+	fieldEnqueuedAt = "enqueued_at"
+	fieldRetryOf    = "retry_of"
+	fieldError      = "error"
+)
+
+// Job 是队列中的一个任务。
+type Job struct {
+	// Id 是 Stream 中的消息 ID
+	Id         string
+	Type       string
+	Payload    []byte
+	Attempt    int
+	EnqueuedAt time.Time
+	carrier    propagation.MapCarrier
+}
+
+// Decode 把 Payload 解析到 v。
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// JobHandler 处理一个任务，返回错误时任务会按退避策略重试，超过最大次数后进入死信队列。
+type JobHandler func(ctx context.Context, job *Job) error
+
+// Queue 是基于 Redis Streams 的持久化任务队列：消费者组保证每个任务只交给一个消费者，
+// 处理成功后 ACK；失败的任务按指数退避放入延迟集合，到期后重新入队；
+// 崩溃的消费者未 ACK 的任务在 VisibilityTimeout 后被其他消费者认领。
+// 所有 key 使用同一个 hash tag，Cluster 模式下位于同一个 slot。
+type Queue struct {
+	Name  string
+	Group string
+	// MaxAttempts 是包括第一次在内的最大执行次数
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	// JobTimeout 是单个任务的执行超时，应小于 VisibilityTimeout，避免任务仍在执行时被认领
+	JobTimeout time.Duration
+	// VisibilityTimeout 是任务未 ACK 多久后视为消费者已崩溃
+	VisibilityTimeout time.Duration
+	// MaxLen 是 Stream 的近似最大长度
+	MaxLen int64
+
+	stream  string
+	delayed string
+	dead    string
+}
+
+// NewQueue 返回名为 name 的队列，使用默认的重试和超时配置。
+func NewQueue(name string) *Queue {
+	base := queueKeyPrefix + "{" + name + "}"
+	return &Queue{
+		Name:              name,
+		Group:             "workers",
+		MaxAttempts:       5,
+		MinBackoff:        time.Second,
+		MaxBackoff:        5 * time.Minute,
+		JobTimeout:        30 * time.Second,
+		VisibilityTimeout: time.Minute,
+		MaxLen:            100000,
+		stream:            base,
+		delayed:           base + ":delayed",
+		dead:              base + ":dead",
+	}
+}
+
+// Enqueue 把任务写入队列，当前 span 的 trace context 写入消息字段，处理任务的 span 会链接到它。
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	ctx, span := otel.Tracer("redis").Start(ctx, q.Name+" publish", gotrace.WithSpanKind(gotrace.SpanKindProducer),
+		gotrace.WithAttributes(q.spanAttrs(jobType)...))
+	defer span.End()
+
+	fields := map[string]any{
+		fieldType:       jobType,
+		fieldPayload:    string(data),
+		fieldAttempt:    "1",
+		fieldEnqueuedAt: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for k, v := range carrier {
+		fields[k] = v
+	}
+
+	id, err := Rdb.XAdd(ctx, &redis.XAddArgs{Stream: q.stream, MaxLen: q.MaxLen, Approx: true, Values: fields}).Result()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "enqueue failed")
+		return "", err
+	}
+	span.SetAttributes(attribute.String("messaging.message.id", id))
+	return id, nil
+}
+
+func (q *Queue) spanAttrs(jobType string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "redis"),
+		attribute.String("messaging.destination.name", q.stream),
+		attribute.String("messaging.consumer.group.name", q.Group),
+		attribute.String("job.type", jobType),
+	}
+}
+
+// defaultConsumerName 使用主机名和进程号区分同一消费者组中的消费者。
+func defaultConsumerName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Work 以 concurrency 个并发消费任务，阻塞直到 ctx 结束。consumer 为空时使用主机名和进程号。
+// 消费者组创建前 Redis 暂时不可用时一直重试，只有无法重试的错误才返回。
+func (q *Queue) Work(ctx context.Context, consumer string, concurrency int, h JobHandler) error {
+	if consumer == "" {
+		consumer = defaultConsumerName()
+	}
+	if err := q.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.readLoop(ctx, consumer, h)
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		q.every(ctx, q.VisibilityTimeout/2, func() error { return q.reclaim(ctx, consumer, h) })
+	}()
+	go func() {
+		defer wg.Done()
+		q.every(ctx, time.Second, func() error { return q.promoteDue(ctx) })
+	}()
+	wg.Wait()
+	return nil
+}
+
+// groupCreateMaxBackoff 是创建消费者组失败后两次重试之间的最长间隔。
+const groupCreateMaxBackoff = 30 * time.Second
+
+// ensureGroup 创建消费者组，已存在时忽略。启动时 Redis 可能还不可用，连接类错误按退避重试直到 ctx 结束，
+// 只有重试也无法解决的错误（如 key 类型不对、没有权限）才返回。
+func (q *Queue) ensureGroup(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := Rdb.XGroupCreateMkStream(ctx, q.stream, q.Group, "0").Err()
+		if err == nil || redis.HasErrorPrefix(err, "BUSYGROUP") {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retryableGroupError(err) {
+			return fmt.Errorf("create consumer group %s: %w", q.Group, err)
+		}
+		wait := min(q.backoff(attempt), groupCreateMaxBackoff)
+		log.Printf("queue %s: create consumer group failed (attempt %d), retry in %s: %v", q.Name, attempt, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryableGroupError 判断错误能否靠重试解决：网络错误都可以，Redis 返回的错误中
+// 只有节点加载数据、主从切换、集群不可用这类暂时状态可以。
+func retryableGroupError(err error) bool {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return true
+	}
+	for _, prefix := range []string{"LOADING", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN"} {
+		if redis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return err.Error() == "ERR max number of clients reached"
+}
+
+func (q *Queue) every(ctx context.Context, interval time.Duration, fn func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := fn(); err != nil && ctx.Err() == nil {
+				log.Printf("queue %s: %v", q.Name, err)
+			}
+		}
+	}
+}
+
+func (q *Queue) readLoop(ctx context.Context, consumer string, h JobHandler) {
+	for ctx.Err() == nil {
+		streams, err := Rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    q.Group,
+			Consumer: consumer,
+			Streams:  []string{q.stream, ">"},
+			Count:    1,
+			Block:    2 * time.Second,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("queue %s: read failed: %v", q.Name, err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				q.process(ctx, msg, h, false)
+			}
+		}
+	}
+}
+
+// reclaim 认领其他消费者超过 VisibilityTimeout 未 ACK 的任务。
+// 投递次数已达 MaxAttempts 的任务（可能每次都让消费者崩溃）直接进入死信队列。
+func (q *Queue) reclaim(ctx context.Context, consumer string, h JobHandler) error {
+	start := "0-0"
+	for {
+		msgs, next, err := Rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   q.stream,
+			Group:    q.Group,
+			Consumer: consumer,
+			MinIdle:  q.VisibilityTimeout,
+			Start:    start,
+			Count:    100,
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("reclaim: %w", err)
+		}
+		for _, msg := range msgs {
+			q.process(ctx, msg, h, true)
+		}
+		if next == "0-0" || len(msgs) == 0 {
+			return nil
+		}
+		start = next
+	}
+}
+
+func parseJob(msg redis.XMessage) *Job {
+	job := &Job{Id: msg.ID, carrier: propagation.MapCarrier{}}
+	for k, v := range msg.Values {
+		s, _ := v.(string)
+		switch k {
+		case fieldType:
+			job.Type = s
+		case fieldPayload:
+			job.Payload = []byte(s)
+		case fieldAttempt:
+			job.Attempt, _ = strconv.Atoi(s)
+		case fieldEnqueuedAt:
+			ms, _ := strconv.ParseInt(s, 10, 64)
+			job.EnqueuedAt = time.UnixMilli(ms)
+		case fieldRetryOf, fieldError:
+		default:
+			job.carrier[k] = s
+		}
+	}
+	if job.Attempt < 1 {
+		job.Attempt = 1
+	}
+	return job
+}
+
+// process 执行一个任务。处理 span 是新的 root，链接到入队时的 span：
+// 一个任务可能在入队很久之后、经过多次重试才被处理，不适合挂在原链路下。
+func (q *Queue) process(ctx context.Context, msg redis.XMessage, h JobHandler, reclaimed bool) {
+	job := parseJob(msg)
+	producer := gotrace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), job.carrier))
+	opts := []gotrace.SpanStartOption{
+		gotrace.WithNewRoot(),
+		gotrace.WithSpanKind(gotrace.SpanKindConsumer),
+		gotrace.WithAttributes(q.spanAttrs(job.Type)...),
+		gotrace.WithAttributes(
+			attribute.String("messaging.message.id", job.Id),
+			attribute.Int("job.attempt", job.Attempt),
+			attribute.Bool("job.reclaimed", reclaimed),
+		),
+	}
+	if producer.IsValid() {
+		opts = append(opts, gotrace.WithLinks(gotrace.Link{SpanContext: producer}))
+	}
+	jctx, span := otel.Tracer("redis").Start(ctx, q.Name+" process", opts...)
+	defer span.End()
+
+	if reclaimed {
+		if n := q.deliveries(ctx, job.Id); n > int64(q.MaxAttempts) {
+			err := fmt.Errorf("delivered %d times without acknowledgement", n)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "dead-lettered")
+			q.finish(jctx, job, msg, err, true)
+			return
+		}
+	}
+	if !job.EnqueuedAt.IsZero() {
+		span.SetAttributes(attribute.Int64("job.queue_time_ms", time.Since(job.EnqueuedAt).Milliseconds()))
+	}
+
+	err := q.run(jctx, job, h)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "job failed")
+	}
+	q.finish(jctx, job, msg, err, err != nil && job.Attempt >= q.MaxAttempts)
+}
+
+func (q *Queue) deliveries(ctx context.Context, id string) int64 {
+	pending, err := Rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: q.stream, Group: q.Group, Start: id, End: id, Count: 1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 0
+	}
+	return pending[0].RetryCount
+}
+
+// run 在 JobTimeout 内执行 handler，panic 视为失败。
+func (q *Queue) run(ctx context.Context, job *Job, h JobHandler) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.JobTimeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// finish 在一个事务中 ACK 原消息，并按结果写入延迟重试集合或死信队列。
+func (q *Queue) finish(ctx context.Context, job *Job, msg redis.XMessage, jobErr error, dead bool) {
+	span := gotrace.SpanFromContext(ctx)
+	pipe := Rdb.TxPipeline()
+	switch {
+	case jobErr == nil:
+	case dead:
+		values := copyFields(msg.Values)
+		values[fieldError] = jobErr.Error()
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: q.dead, MaxLen: q.MaxLen, Approx: true, Values: values})
+		span.AddEvent("job.dead_lettered", gotrace.WithAttributes(attribute.String("job.error", jobErr.Error())))
+	default:
+		values := copyFields(msg.Values)
+		values[fieldAttempt] = strconv.Itoa(job.Attempt + 1)
+		values[fieldRetryOf] = job.Id
+		member, _ := json.Marshal(values)
+		backoff := q.backoff(job.Attempt)
+		pipe.ZAdd(ctx, q.delayed, redis.Z{Score: float64(time.Now().Add(backoff).UnixMilli()), Member: member})
+		span.AddEvent("job.retry_scheduled", gotrace.WithAttributes(
+			attribute.Int("job.next_attempt", job.Attempt+1),
+			attribute.Int64("job.backoff_ms", backoff.Milliseconds()),
+		))
+	}
+	pipe.XAck(ctx, q.stream, q.Group, job.Id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// 没能 ACK 的任务会在 VisibilityTimeout 后被重新认领
+		span.RecordError(err)
+		log.Printf("queue %s: finish job %s failed: %v", q.Name, job.Id, err)
+	}
+}
+
+func copyFields(values map[string]any) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = fmt.Sprint(v)
+	}
+	return out
+}
+
+// backoff 返回第 attempt 次失败后的等待时间：MinBackoff * 2^(attempt-1)，不超过 MaxBackoff，带 ±20% 抖动。
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.MaxBackoff
+	if attempt < 32 {
+		d = min(q.MinBackoff<<(attempt-1), q.MaxBackoff)
+	}
+	jitter := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(d) * jitter)
+}
+
+// promoteDueScript 把到期的重试任务从延迟集合移回 Stream。
+var promoteDueScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, member in ipairs(due) do
+	local fields = cjson.decode(member)
+	local args = {}
+	for k, v in pairs(fields) do
+		table.insert(args, k)
+		table.insert(args, v)
+	end
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[3], "*", unpack(args))
+	redis.call("ZREM", KEYS[1], member)
+end
+return #due
+`)
+
+func (q *Queue) promoteDue(ctx context.Context) error {
+	for {
+		n, err := promoteDueScript.Run(ctx, Rdb, []string{q.delayed, q.stream},
+			time.Now().UnixMilli(), 100, q.MaxLen).Int()
+		if err != nil {
+			return fmt.Errorf("promote retries: %w", err)
+		}
+		if n < 100 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit 是 GCRA 限流配额：每 Period 补充 Rate 个请求，最多累积 Burst 个。
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s,%d", l.Rate, l.Period, l.Burst)
+}
+
+// RateLimitResult 是一次限流判断的结果。
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining 是此刻还能立即通过的请求数
+	Remaining int
+	// RetryAfter 是被拒绝时需要等待的时长，允许时为 0
+	RetryAfter time.Duration
+	// ResetAfter 是额度完全恢复到 Burst 所需的时长
+	ResetAfter time.Duration
+}
+
+// gcraScript 以 Redis 服务端时间实现 GCRA，保证多个实例之间的判断是原子且一致的。
+// key 中保存理论到达时间（TAT），额度恢复后自动过期。浮点数以字符串返回，避免被 Redis 截断为整数。
+var gcraScript = redis.NewScript(`
+local key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local emission_interval = period / rate
+local increment = emission_interval * cost
+local burst_offset = emission_interval * burst
+
+redis.replicate_commands()
+local t = redis.call("TIME")
+-- 减去一个固定的纪元，保留微秒精度
+local now = (t[1] - 1700000000) + (t[2] / 1000000)
+
+local tat = tonumber(redis.call("GET", key))
+if not tat or tat < now then
+	tat = now
+end
+
+local new_tat = tat + increment
+local diff = now - (new_tat - burst_offset)
+local remaining = diff / emission_interval
+
+if remaining < 0 then
+	return {0, 0, tostring(-diff), tostring(tat - now)}
+end
+
+local reset_after = new_tat - now
+redis.call("SET", key, tostring(new_tat), "EX", math.ceil(reset_after))
+return {1, math.floor(remaining), "0", tostring(reset_after)}
+`)
+
+// AllowN 判断 key 在配额 limit 下能否通过 n 个请求。
+func AllowN(ctx context.Context, key string, limit Limit, n int) (*RateLimitResult, error) {
+	values, err := gcraScript.Run(ctx, Rdb, []string{key},
+		limit.Burst, limit.Rate, limit.Period.Seconds(), n).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("ratelimit: unexpected script result %v", values)
+	}
+
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	res := &RateLimitResult{Allowed: allowed == 1, Remaining: int(remaining)}
+	if res.RetryAfter, err = parseSeconds(values[2]); err != nil {
+		return nil, err
+	}
+	if res.ResetAfter, err = parseSeconds(values[3]); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func parseSeconds(v any) (time.Duration, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("ratelimit: unexpected value %v", v)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
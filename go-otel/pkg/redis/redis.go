@@ -1,73 +1,62 @@
 package redis
 
 import (
-	"os"
-	"strconv"
+	"fmt"
 	"log"
-	"time"
 	"context"
-	"math/rand"
-	"errors"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/redis/go-redis/extra/redisotel/v9"
-	logx "github.com/flashcatcloud/Demo/go-otel/pkg/log"
+	"go.opentelemetry.io/otel/attribute"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
 )
 
-var Rdb *redis.Client
+// Rdb 是全局 Redis 客户端，按 REDIS_MODE 可能是单机、Sentinel 或 Cluster 客户端。
+var Rdb redis.UniversalClient
 
 func Init() {
 	initRedis()
-	// 启用 tracing
-	if err := redisotel.InstrumentTracing(Rdb); err != nil {
+	if err := initScenarios(); err != nil {
+		panic(fmt.Errorf("redis scenarios: %w", err))
+	}
+	// 所有模式统一启用 tracing 和 metrics，Cluster 模式下也会作用于自动发现的每个节点
+	attrs := redisotel.WithAttributes(attribute.String("redis.mode", mode))
+	if err := redisotel.InstrumentTracing(Rdb, attrs); err != nil {
 		panic(err)
 	}
-}
-
-func initRedis() {
-	var db int
-	dbStr := os.Getenv("REDIS_DB")
-	if dbStr == "" {
-		db = 11
-	} else {
-		db, _ = strconv.Atoi(dbStr)
+	if err := redisotel.InstrumentMetrics(Rdb, attrs); err != nil {
+		panic(err)
 	}
-
-	log.Printf("db:%v,addr:%v, passwd:%v\n", db, os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"))
-	Rdb = redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_ADDR"),
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       db,
-	})
-}
-
-func DoSomething(ctx context.Context, rdb *redis.Client) error {
-	// 6% 概率返回错误
-	if rand.Float64() < 0.2 {
-		return errors.New("random error for testing")
+	if err := registerMetrics(); err != nil {
+		panic(err)
 	}
+	// 在 tracing 之后添加，注入的故障会记录在命令的 span 上
+	Rdb.AddHook(faultHook{})
+}
 
-	if err := rdb.Set(ctx, "go-demo:hello", "world", time.Minute).Err(); err != nil {
-		return err
-	}
-	logx.Logger.InfoContext(ctx, "go-demo:hello set")
-	if err := rdb.Set(ctx, "go-demo:tag", "OTel", time.Minute).Err(); err != nil {
-		return err
-	}
-	logx.Logger.InfoContext(ctx, "go-demo:tag set")
+// mode 是当前使用的部署模式。
+var mode string
 
-	val := rdb.Get(ctx, "go-demo:tag").Val()
-	if val != "OTel" {
-		return errors.New("tag not found")
-	}
+func initRedis() {
+	conf, err := loadConfig()
+	if err != nil {
+		panic(fmt.Errorf("redis config: %w", err))
+	}
+	// 只打印配置摘要，不输出密码
+	log.Printf("redis: %s", conf)
+	mode = conf.mode
+	Rdb = conf.newClient()
+}
 
-	if err := rdb.Del(ctx, "go-demo:name").Err(); err != nil {
+// DoSomething 执行一次 Redis 访问场景，场景由 WithScenario 指定，未指定时使用 REDIS_SCENARIO。
+func DoSomething(ctx context.Context, rdb redis.UniversalClient) error {
+	// 故障由 fault 规则注入，默认规则保留原先 20% 概率失败的演示行为
+	if err := fault.Inject(ctx, fault.TargetRedis+".do_something"); err != nil {
 		return err
 	}
-	logx.Logger.With("hello", "flashcat").InfoContext(ctx, "go-demo:name deleted")
-	if err := rdb.Del(ctx, "go-demo:tag").Err(); err != nil {
+	s, err := scenarioFromContext(ctx)
+	if err != nil {
 		return err
 	}
-	logx.Logger.InfoContext(ctx, "tag deleted")
-	return nil
+	return RunScenario(ctx, rdb, s)
 }
@@ -18,12 +18,15 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/mcp"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/model"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
 	pkgotel "github.com/flashcatcloud/Demo/go-otel/pkg/otel"
 )
 
 func init() {
+	fault.Init()
 	redis.Init()
 	model.Init()
 }
@@ -36,7 +39,8 @@ func main() {
 	shutdown := pkgotel.InitOpenTelemetry()
 	defer shutdown()
 
-	model.RecordMetrics()
+	model.RecordMetrics(ctx)
+	mcp.StartHealthMonitor(ctx)
 
 	r := gin.Default()
 	r.Use(otelgin.Middleware(os.Getenv("OTEL_SERVICE_NAME")))
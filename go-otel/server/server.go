@@ -16,12 +16,16 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	_ "github.com/go-sql-driver/mysql"
 
+	"github.com/flashcatcloud/Demo/go-otel/pkg/fault"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/mcp"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/otel"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/model"
+	"github.com/flashcatcloud/Demo/go-otel/pkg/random"
 	"github.com/flashcatcloud/Demo/go-otel/pkg/redis"
 )
 
 func init() {
+	fault.Init()
 	redis.Init()
 	model.Init()
 }
@@ -31,7 +35,7 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	model.RecordMetrics()
+	model.RecordMetrics(ctx)
 
 	// 设置 OpenTelemetry.
 	otelShutdown, err := otel.SetupOTelSDK(ctx)
@@ -43,8 +47,20 @@ func main() {
 		err = errors.Join(err, otelShutdown(ctx))
 	}()
 
+	// 把 outbox 中的用户变更事件投递到 Redis Stream
+	model.StartOutboxRelay(ctx)
+	model.StartSearchIndexRefresh(ctx)
+	model.StartRollStream(ctx)
+	model.StartJobWorkers(ctx)
+	redis.StartHealthProbe(ctx)
+	mcp.StartHealthMonitor(ctx)
+
 	r := gin.Default()
 	r.Use(otelgin.Middleware(os.Getenv("OTEL_SERVICE_NAME")))
+	// 按请求注入可重放的随机数来源，需在 otelgin 之后以便记录到 span 上
+	r.Use(random.Middleware())
+	r.Use(model.FaultInjection())
+	r.Use(model.RedisScenario())
 	pprof.Register(r)
 
 	r.GET("/", func(c *gin.Context) {
@@ -53,12 +69,40 @@ func main() {
 
 	// 添加metrics接口
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
-	r.GET("/roll", model.Roll)
-	r.POST("/roll2", model.Roll)
+	r.GET("/readyz", model.Readiness)
+	// 按 API key（没有时按 IP）限流，配额可通过 RATE_LIMIT_ROLL、RATE_LIMIT_USER 覆盖
+	rollLimit := model.RateLimit("roll", "10/1s,20", model.RateLimitByClient)
+	userLimit := model.RateLimit("user", "20/1s,40", model.RateLimitByClient)
+	// 批量导入会占满数据库写入，不论来自哪个客户端都共享一份额度，可通过 RATE_LIMIT_IMPORT 覆盖
+	importLimit := model.RateLimit("import", "2/1s,5", model.RateLimitByRoute)
+
+	r.GET("/roll", rollLimit, model.Roll)
+	r.POST("/roll2", rollLimit, model.Roll)
+	r.GET("/rolls", rollLimit, model.ListRolls)
+	r.GET("/rolls/stats", rollLimit, model.RollStatsHandler)
+	r.GET("/rolls/stream", model.StreamRolls)
+	r.GET("/ws", model.RollsWebSocket)
+
+	r.POST("/user", userLimit, model.Idempotency(), model.CreateUser)
+	r.GET("/user", userLimit, model.GetUser)
+	r.GET("/users", userLimit, model.ListUsers)
+	r.GET("/users/search", userLimit, model.SearchUsers)
+	r.PUT("/users/:id", userLimit, model.UpdateUser)
+	r.DELETE("/users/:id", userLimit, model.DeleteUser)
+	// Google API 风格的自定义方法：POST /users:import, GET /users:export
+	r.POST("/users:method", userLimit, importLimit, model.CustomMethods(map[string]gin.HandlerFunc{
+		"import": model.ImportUsers,
+	}))
+	r.GET("/users:method", userLimit, model.CustomMethods(map[string]gin.HandlerFunc{
+		"export": model.ExportUsers,
+	}))
 
-	r.POST("/user", model.CreateUser)
-	r.GET("/user", model.GetUser)
-	r.GET("/users", model.ListUsers)
+	// 管理接口，配置 ADMIN_TOKEN 后需要 Bearer 认证
+	admin := r.Group("/admin", model.AdminAuth())
+	admin.GET("/faults", model.ListFaults)
+	admin.POST("/faults", model.CreateFault)
+	admin.DELETE("/faults", model.ClearFaults)
+	admin.DELETE("/faults/:id", model.DeleteFault)
 
 	srv := http.Server{
 		Addr:    fmt.Sprintf(":%s", os.Getenv("GO_DEMO_SERVER_PORT")),
@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 部署模式，由 REDIS_MODE 指定。
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// defaultDB 是单机和 Sentinel 模式下默认使用的库，Cluster 模式只有 0 号库。
+const defaultDB = 11
+
+// config 是从环境变量读取的连接配置：
+//
+//	REDIS_MODE                      standalone（默认）、sentinel 或 cluster；设置了 REDIS_SENTINEL_MASTER 时默认 sentinel
+//	REDIS_ADDR                      逗号分隔的地址：单机地址、Sentinel 地址列表或 Cluster 种子节点，默认 localhost:6379
+//	REDIS_DB                        库编号，默认 11，Cluster 模式不可设置
+//	REDIS_USERNAME、REDIS_PASSWORD  ACL 用户名和密码
+//	REDIS_SENTINEL_MASTER           Sentinel 监控的主节点名
+//	REDIS_SENTINEL_USERNAME、REDIS_SENTINEL_PASSWORD  连接 Sentinel 本身的凭据
+//	REDIS_TLS                       为 true 时启用 TLS，设置了任一 REDIS_TLS_* 文件时自动启用
+//	REDIS_TLS_CA_FILE、REDIS_TLS_CERT_FILE、REDIS_TLS_KEY_FILE、REDIS_TLS_SERVER_NAME、REDIS_TLS_INSECURE_SKIP_VERIFY
+type config struct {
+	mode string
+	opts *redis.UniversalOptions
+}
+
+func loadConfig() (*config, error) {
+	opts := &redis.UniversalOptions{
+		Username:         os.Getenv("REDIS_USERNAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		MasterName:       os.Getenv("REDIS_SENTINEL_MASTER"),
+		SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+	}
+	for _, addr := range strings.Split(os.Getenv("REDIS_ADDR"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			opts.Addrs = append(opts.Addrs, addr)
+		}
+	}
+	if len(opts.Addrs) == 0 {
+		// 与 go-redis 的默认值一致
+		opts.Addrs = []string{"localhost:6379"}
+	}
+
+	mode := os.Getenv("REDIS_MODE")
+	if mode == "" {
+		mode = ModeStandalone
+		if opts.MasterName != "" {
+			mode = ModeSentinel
+		}
+	}
+
+	dbStr := os.Getenv("REDIS_DB")
+	switch mode {
+	case ModeStandalone:
+		if len(opts.Addrs) > 1 {
+			return nil, errors.New("standalone mode takes a single REDIS_ADDR; set REDIS_MODE=cluster or sentinel for multiple addresses")
+		}
+	case ModeSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("REDIS_SENTINEL_MASTER is required in sentinel mode")
+		}
+	case ModeCluster:
+		if dbStr != "" && dbStr != "0" {
+			return nil, errors.New("REDIS_DB is not supported in cluster mode")
+		}
+	default:
+		return nil, fmt.Errorf("unknown REDIS_MODE %q", mode)
+	}
+	if mode != ModeCluster {
+		opts.DB = defaultDB
+		if dbStr != "" {
+			db, err := strconv.Atoi(dbStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid REDIS_DB %q", dbStr)
+			}
+			opts.DB = db
+		}
+	}
+
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts.TLSConfig = tlsConfig
+	return &config{mode: mode, opts: opts}, nil
+}
+
+func loadTLSConfig() (*tls.Config, error) {
+	caFile := os.Getenv("REDIS_TLS_CA_FILE")
+	certFile := os.Getenv("REDIS_TLS_CERT_FILE")
+	keyFile := os.Getenv("REDIS_TLS_KEY_FILE")
+	enabled, _ := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	if !enabled && caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	insecure, _ := strconv.ParseBool(os.Getenv("REDIS_TLS_INSECURE_SKIP_VERIFY"))
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         os.Getenv("REDIS_TLS_SERVER_NAME"),
+		InsecureSkipVerify: insecure,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read REDIS_TLS_CA_FILE: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("REDIS_TLS_CA_FILE contains no certificates")
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load redis client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// newClient 按模式创建客户端。Sentinel 模式返回的也是 *redis.Client，会自动跟随主从切换。
+func (c *config) newClient() redis.UniversalClient {
+	switch c.mode {
+	case ModeSentinel:
+		return redis.NewFailoverClient(c.opts.Failover())
+	case ModeCluster:
+		return redis.NewClusterClient(c.opts.Cluster())
+	}
+	return redis.NewClient(c.opts.Simple())
+}
+
+// String 返回可以写入日志的配置摘要，只说明是否设置了密码，不包含密码本身。
+func (c *config) String() string {
+	secret := func(s string) string {
+		if s == "" {
+			return "unset"
+		}
+		return "set"
+	}
+	s := fmt.Sprintf("mode=%s addrs=%s db=%d username=%q password=%s tls=%t",
+		c.mode, strings.Join(c.opts.Addrs, ","), c.opts.DB, c.opts.Username, secret(c.opts.Password), c.opts.TLSConfig != nil)
+	if c.mode == ModeSentinel {
+		s += fmt.Sprintf(" master=%s sentinel_username=%q sentinel_password=%s",
+			c.opts.MasterName, c.opts.SentinelUsername, secret(c.opts.SentinelPassword))
+	}
+	return s
+}
@@ -1 +1,46 @@
+// Package redis 提供与 go-otel 相同的 Redis 连接配置、健康探测和访问场景，
+// 使用 SkyWalking toolkit 的 local span 记录场景和每一步，命令本身由 SkyWalking agent 自动埋点。
 package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Rdb 是全局 Redis 客户端，按 REDIS_MODE 可能是单机、Sentinel 或 Cluster 客户端。
+var Rdb redis.UniversalClient
+
+// Logger 用于记录场景中 step 的日志，由 main 替换为带 trace 上下文的 logger。
+var Logger = slog.Default()
+
+// mode 是当前使用的部署模式。
+var mode string
+
+// Init 按环境变量创建 Rdb 并加载场景，配置错误时 panic。
+func Init() {
+	conf, err := loadConfig()
+	if err != nil {
+		panic(fmt.Errorf("redis config: %w", err))
+	}
+	// 只打印配置摘要，不输出密码
+	log.Printf("redis: %s", conf)
+	mode = conf.mode
+	Rdb = conf.newClient()
+
+	if err := initScenarios(); err != nil {
+		panic(fmt.Errorf("redis scenarios: %w", err))
+	}
+}
+
+// DoSomething 执行一次 Redis 访问场景，场景由 WithScenario 指定，未指定时使用 REDIS_SCENARIO。
+func DoSomething(ctx context.Context, rdb redis.UniversalClient) error {
+	s, err := scenarioFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return RunScenario(ctx, rdb, s)
+}
@@ -0,0 +1,388 @@
+package redis
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/skywalking-go/toolkit/trace"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed scenarios.yaml
+var builtinScenarios []byte
+
+const (
+	defaultKeySpaceSize = 1000
+	defaultPayloadSize  = 16
+	maxPayloadSize      = 16 << 20
+	maxRepeat           = 1000
+)
+
+// ScenarioTag 是记录场景名称的 span tag。
+const ScenarioTag = "redis.scenario"
+
+var scenarioDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "redis_scenario_duration_seconds",
+	Help: "The duration of running a DoSomething scenario",
+}, []string{"scenario", "result"})
+
+func init() {
+	prometheus.MustRegister(scenarioDuration)
+}
+
+// ErrUnknownScenario 表示没有指定名称的场景。
+var ErrUnknownScenario = errors.New("redis: unknown scenario")
+
+// KeySpace 描述 {key} 占位符的取值范围：Prefix 加上 [0, Size) 中的随机整数。
+type KeySpace struct {
+	Prefix string `yaml:"prefix"`
+	Size   int    `yaml:"size"`
+}
+
+// Step 是场景中的一步，Command、Pipeline、Multi 和 Script 有且只有一个。
+type Step struct {
+	Name string `yaml:"name"`
+	// Command 是一条 Redis 命令及参数
+	Command []string `yaml:"command"`
+	// Expect 非空时要求命令的返回值等于它，不存在的 key 返回 "(nil)"
+	Expect string `yaml:"expect"`
+	// Pipeline 中的命令一次发送，Multi 中的命令包在 MULTI/EXEC 事务中发送
+	Pipeline []Step `yaml:"pipeline"`
+	Multi    []Step `yaml:"multi"`
+	// Script 是 Lua 脚本，以 EVALSHA 执行，Keys 和 Args 分别对应 KEYS 和 ARGV
+	Script string   `yaml:"script"`
+	Keys   []string `yaml:"keys"`
+	Args   []string `yaml:"args"`
+	// Repeat 是重复执行的次数，每次重新选择 key
+	Repeat int `yaml:"repeat"`
+	// Log 非空时在这一步成功后记录一条日志
+	Log string `yaml:"log"`
+
+	script *redis.Script
+}
+
+// Scenario 是一个 Redis 访问场景。
+type Scenario struct {
+	Name        string   `yaml:"name"`
+	KeySpace    KeySpace `yaml:"keyspace"`
+	PayloadSize int      `yaml:"payload_size"`
+	Steps       []Step   `yaml:"steps"`
+}
+
+type scenarioFile struct {
+	Scenarios []*Scenario `yaml:"scenarios"`
+}
+
+// ParseScenarios 解析 YAML 格式的场景定义并校验。
+func ParseScenarios(data []byte) ([]*Scenario, error) {
+	var f scenarioFile
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	for _, s := range f.Scenarios {
+		if err := s.prepare(); err != nil {
+			return nil, err
+		}
+	}
+	return f.Scenarios, nil
+}
+
+func (s *Scenario) prepare() error {
+	if s.Name == "" {
+		return errors.New("scenario: name is required")
+	}
+	if s.KeySpace.Size == 0 {
+		s.KeySpace.Size = defaultKeySpaceSize
+	}
+	if s.PayloadSize == 0 {
+		s.PayloadSize = defaultPayloadSize
+	}
+	if s.KeySpace.Size < 0 {
+		return fmt.Errorf("scenario %s: keyspace.size must be positive", s.Name)
+	}
+	if s.PayloadSize < 0 || s.PayloadSize > maxPayloadSize {
+		return fmt.Errorf("scenario %s: payload_size must be between 1 and %d", s.Name, maxPayloadSize)
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("scenario %s: at least one step is required", s.Name)
+	}
+	for i := range s.Steps {
+		if err := s.Steps[i].prepare(s.Name, i, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *Step) prepare(scenario string, i int, nested bool) error {
+	kinds := 0
+	for _, set := range []bool{len(st.Command) > 0, len(st.Pipeline) > 0, len(st.Multi) > 0, st.Script != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("scenario %s step %d: exactly one of command, pipeline, multi or script is required", scenario, i)
+	}
+	if nested && len(st.Command) == 0 {
+		return fmt.Errorf("scenario %s step %d: pipeline and multi may only contain commands", scenario, i)
+	}
+	if st.Repeat < 0 || st.Repeat > maxRepeat {
+		return fmt.Errorf("scenario %s step %d: repeat must be between 0 and %d", scenario, i, maxRepeat)
+	}
+	for j := range st.Pipeline {
+		if err := st.Pipeline[j].prepare(scenario, i, true); err != nil {
+			return err
+		}
+	}
+	for j := range st.Multi {
+		if err := st.Multi[j].prepare(scenario, i, true); err != nil {
+			return err
+		}
+	}
+	if st.Script != "" {
+		st.script = redis.NewScript(st.Script)
+	}
+	if st.Name == "" {
+		switch {
+		case len(st.Command) > 0:
+			st.Name = strings.ToUpper(st.Command[0])
+		case len(st.Pipeline) > 0:
+			st.Name = "pipeline"
+		case len(st.Multi) > 0:
+			st.Name = "multi"
+		default:
+			st.Name = "script"
+		}
+	}
+	return nil
+}
+
+var (
+	scenariosMu     sync.RWMutex
+	scenarios       = map[string]*Scenario{}
+	defaultScenario = "default"
+)
+
+// initScenarios 加载内置场景，以及 REDIS_SCENARIO_FILE 中的场景（同名时覆盖内置场景），
+// REDIS_SCENARIO 选择默认执行的场景。
+func initScenarios() error {
+	all, err := ParseScenarios(builtinScenarios)
+	if err != nil {
+		return fmt.Errorf("builtin scenarios: %w", err)
+	}
+	if path := os.Getenv("REDIS_SCENARIO_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		custom, err := ParseScenarios(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		all = append(all, custom...)
+	}
+
+	scenariosMu.Lock()
+	defer scenariosMu.Unlock()
+	for _, s := range all {
+		scenarios[s.Name] = s
+	}
+	if v := os.Getenv("REDIS_SCENARIO"); v != "" {
+		defaultScenario = v
+	}
+	if _, ok := scenarios[defaultScenario]; !ok {
+		return fmt.Errorf("REDIS_SCENARIO: %w %q", ErrUnknownScenario, defaultScenario)
+	}
+	return nil
+}
+
+// Scenarios 返回所有场景的名称。
+func Scenarios() []string {
+	scenariosMu.RLock()
+	defer scenariosMu.RUnlock()
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasScenario 判断是否存在名为 name 的场景。
+func HasScenario(name string) bool {
+	scenariosMu.RLock()
+	defer scenariosMu.RUnlock()
+	_, ok := scenarios[name]
+	return ok
+}
+
+type scenarioCtxKey struct{}
+
+// WithScenario 返回指定 DoSomething 执行场景的 context。
+func WithScenario(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, scenarioCtxKey{}, name)
+}
+
+func scenarioFromContext(ctx context.Context) (*Scenario, error) {
+	scenariosMu.RLock()
+	defer scenariosMu.RUnlock()
+	name, _ := ctx.Value(scenarioCtxKey{}).(string)
+	if name == "" {
+		name = defaultScenario
+	}
+	s, ok := scenarios[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownScenario, name)
+	}
+	return s, nil
+}
+
+// scenarioRun 是一次场景的执行。
+type scenarioRun struct {
+	s *Scenario
+}
+
+func (r *scenarioRun) expand(args []string, key string) []any {
+	out := make([]any, len(args))
+	for i, a := range args {
+		out[i] = r.expandOne(a, key)
+	}
+	return out
+}
+
+func (r *scenarioRun) expandOne(a, key string) string {
+	if strings.HasPrefix(a, "{payload") && strings.HasSuffix(a, "}") {
+		size := r.s.PayloadSize
+		if n, ok := strings.CutPrefix(a[1:len(a)-1], "payload:"); ok {
+			if v, err := strconv.Atoi(n); err == nil && v > 0 && v <= maxPayloadSize {
+				size = v
+			}
+		}
+		return r.payload(size)
+	}
+	return strings.ReplaceAll(a, "{key}", key)
+}
+
+const payloadAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// payload 返回 size 字节的内容：随机选择 16 字节后重复，避免大 payload 逐字节取随机数。
+func (r *scenarioRun) payload(size int) string {
+	chunk := make([]byte, 16)
+	for i := range chunk {
+		chunk[i] = payloadAlphabet[rand.Intn(len(payloadAlphabet))]
+	}
+	return strings.Repeat(string(chunk), size/16+1)[:size]
+}
+
+func (r *scenarioRun) key() string {
+	return r.s.KeySpace.Prefix + strconv.Itoa(rand.Intn(r.s.KeySpace.Size))
+}
+
+// RunScenario 在 rdb 上执行一次场景 s，整个场景和每一步各有一个 local span，
+// 耗时记录到 redis_scenario_duration_seconds。
+func RunScenario(ctx context.Context, rdb redis.UniversalClient, s *Scenario) (err error) {
+	span, _ := trace.CreateLocalSpan("redis.scenario " + s.Name)
+	span.SetTag(ScenarioTag, s.Name)
+	start := time.Now()
+	defer func() {
+		result := "ok"
+		if err != nil {
+			result = "error"
+			span.SetTag("error", err.Error())
+		}
+		scenarioDuration.WithLabelValues(s.Name, result).Observe(time.Since(start).Seconds())
+		trace.StopSpan()
+	}()
+
+	run := &scenarioRun{s: s}
+	for i := range s.Steps {
+		st := &s.Steps[i]
+		for n := 0; n < max(st.Repeat, 1); n++ {
+			if err := run.step(ctx, rdb, i, st); err != nil {
+				return err
+			}
+		}
+		if st.Log != "" {
+			Logger.InfoContext(ctx, st.Log)
+		}
+	}
+	return nil
+}
+
+func (r *scenarioRun) step(ctx context.Context, rdb redis.UniversalClient, i int, st *Step) (err error) {
+	key := r.key()
+	span, _ := trace.CreateLocalSpan(st.Name)
+	span.SetTag("redis.scenario.step", strconv.Itoa(i))
+	span.SetTag("redis.scenario.key", key)
+	defer func() {
+		if err != nil {
+			span.SetTag("error", err.Error())
+		}
+		trace.StopSpan()
+	}()
+
+	switch {
+	case len(st.Command) > 0:
+		cmd := rdb.Do(ctx, r.expand(st.Command, key)...)
+		return checkReply(st, cmd)
+	case st.script != nil:
+		keys := make([]string, len(st.Keys))
+		for j, k := range st.Keys {
+			keys[j] = r.expandOne(k, key)
+		}
+		return st.script.Run(ctx, rdb, keys, r.expand(st.Args, key)...).Err()
+	default:
+		cmds := st.Pipeline
+		pipe := rdb.Pipeline()
+		if len(st.Multi) > 0 {
+			cmds = st.Multi
+			pipe = rdb.TxPipeline()
+		}
+		results := make([]*redis.Cmd, len(cmds))
+		for j, c := range cmds {
+			results[j] = pipe.Do(ctx, r.expand(c.Command, key)...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for j := range cmds {
+			if err := checkReply(&cmds[j], results[j]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkReply 检查命令的错误和期望值，key 不存在不视为错误。
+func checkReply(st *Step, cmd *redis.Cmd) error {
+	val, err := cmd.Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if st.Expect == "" {
+		return nil
+	}
+	got := "(nil)"
+	if err == nil {
+		got = fmt.Sprint(val)
+	}
+	if got != st.Expect {
+		return fmt.Errorf("%s: expected %q, got %q", st.Name, st.Expect, got)
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"log/slog"
@@ -11,7 +10,6 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"syscall"
 	"time"
 
@@ -21,10 +19,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/redis/go-redis/v9"
 	sloglogrus "github.com/samber/slog-logrus/v2"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/flashcatcloud/Demo/go-skywalking/pkg/redis"
 )
 
 var (
@@ -33,7 +32,6 @@ var (
 		Name: "myapp_processed_ops_total",
 		Help: "The total number of processed events",
 	})
-	rdb    *redis.Client
 	rng    *rand.Rand
 	logger *slog.Logger
 )
@@ -64,23 +62,8 @@ func initLog() {
 
 func init() {
 	initLog()
-	initRedis()
-}
-
-func initRedis() {
-	var db int
-	dbStr := os.Getenv("REDIS_DB")
-	if dbStr == "" {
-		db = 11
-	} else {
-		db, _ = strconv.Atoi(dbStr)
-	}
-
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_ADDR"),
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       db,
-	})
+	redis.Logger = logger
+	redis.Init()
 }
 
 func recordMetrics() {
@@ -106,6 +89,7 @@ func main() {
 	defer stop()
 
 	recordMetrics()
+	redis.StartHealthProbe(ctx)
 
 	r := gin.Default()
 	pprof.Register(r)
@@ -116,6 +100,7 @@ func main() {
 
 	// 添加metrics接口
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/readyz", readiness)
 	r.GET("/roll", roll)
 
 	srv := http.Server{
@@ -148,8 +133,31 @@ func main() {
 	log.Println("Server exiting")
 }
 
+// scenarioHeader 指定本次请求执行的 Redis 场景，与 go-otel 相同。
+const scenarioHeader = "X-Redis-Scenario"
+
+// readiness 依据 Redis 健康探测的结果返回 200 或 503。
+func readiness(c *gin.Context) {
+	h := redis.CurrentHealth()
+	status, code := "ready", http.StatusOK
+	if !h.Healthy {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": gin.H{"redis": h},
+	})
+}
+
 func roll(c *gin.Context) {
 	ctx := c.Request.Context()
+	if name := c.GetHeader(scenarioHeader); name != "" {
+		if !redis.HasScenario(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown redis scenario %q", name), "scenarios": redis.Scenarios()})
+			return
+		}
+		ctx = redis.WithScenario(ctx, name)
+	}
 	number := rollOnce(ctx)
 
 	opsProcessed.Inc()
@@ -168,7 +176,7 @@ func rollOnce(ctx context.Context) int {
 	slackOff()
 
 	number := 1 + rng.Intn(6)
-	if err := doSomething(ctx, rdb); err != nil {
+	if err := redis.DoSomething(ctx, redis.Rdb); err != nil {
 		log.Printf("doSomething failed:%v\n!", err)
 	}
 
@@ -184,33 +192,3 @@ func slackOff() {
 
 	time.Sleep(time.Duration(rng.Intn(2000)) * time.Microsecond)
 }
-
-func doSomething(ctx context.Context, rdb *redis.Client) error {
-	span, _ := trace.CreateLocalSpan("doSomething")
-	span.SetTag("c", "d")
-	defer trace.StopSpan()
-
-	if err := rdb.Set(ctx, "go-demo:hello", "world", time.Minute).Err(); err != nil {
-		return err
-	}
-	logger.InfoContext(ctx, "go-demo:hello set")
-	if err := rdb.Set(ctx, "go-demo:tag", "skywalking", time.Minute).Err(); err != nil {
-		return err
-	}
-	logger.InfoContext(ctx, "go-demo:tag set")
-
-	val := rdb.Get(ctx, "go-demo:tag").Val()
-	if val != "skywalking" {
-		return errors.New("tag not found")
-	}
-
-	if err := rdb.Del(ctx, "go-demo:name").Err(); err != nil {
-		return err
-	}
-	logger.With("hello", "flashcat").InfoContext(ctx, "go-demo:name deleted")
-	if err := rdb.Del(ctx, "go-demo:tag").Err(); err != nil {
-		return err
-	}
-	logger.InfoContext(ctx, "tag deleted")
-	return nil
-}